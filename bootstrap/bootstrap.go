@@ -1,14 +1,16 @@
 package bootstrap
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/atsegelnyk/galaxia/entityregistry"
 	"github.com/atsegelnyk/galaxia/model"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"io"
 	"os"
-	"text/template"
+	"regexp"
+	"strings"
 )
 
 func FromFile(path string) (*entityregistry.Registry, error) {
@@ -70,21 +72,22 @@ func readConfigFromFile(path string) (*BotSchema, error) {
 func bootstrapAction(actionSchema ActionSchema, er *entityregistry.Registry) error {
 	action := model.NewAction(actionSchema.Name, func(ctx *model.UserContext, update *tgbotapi.Update) *model.UserUpdate {
 
-		msgText, err := executeUserTemplate(actionSchema.Message, ctx)
+		parseMode := bootstrapParseMode(actionSchema.ParseMode)
+		msgText, err := executeUserTemplate(actionSchema.Message, parseMode, ctx)
 		var message *model.Message
 		if err != nil {
 			message = model.NewMessage(
 				model.WithText(err.Error()),
 			)
 		} else {
-			message = model.NewMessage(model.WithText(msgText))
+			message = model.NewMessage(
+				model.WithText(msgText),
+				model.WithParseMode(parseMode),
+			)
 		}
 		var transitOption model.UserUpdateOption
 		if actionSchema.Transit != nil {
-			transitOption = model.WithTransit(
-				model.ResourceRef(actionSchema.Transit.TargetRef),
-				actionSchema.Transit.Clean,
-			)
+			transitOption = bootstrapTransit(actionSchema.Transit)
 		}
 		return model.NewUserUpdate(update.Message.Chat.ID,
 			model.WithMessages(message),
@@ -92,9 +95,26 @@ func bootstrapAction(actionSchema ActionSchema, er *entityregistry.Registry) err
 		)
 	})
 
+	var targets []model.ResourceRef
+	if actionSchema.Transit != nil && actionSchema.Transit.Mode != "POP" {
+		targets = append(targets, model.ResourceRef(actionSchema.Transit.TargetRef))
+	}
+	action.WithTransitTargets(targets...)
 	return er.RegisterAction(action)
 }
 
+func bootstrapTransit(transitSchema *TransitSchema) model.UserUpdateOption {
+	targetRef := model.ResourceRef(transitSchema.TargetRef)
+	switch transitSchema.Mode {
+	case "REPLACE":
+		return model.WithTransitReplace(targetRef, transitSchema.Clean)
+	case "POP":
+		return model.WithTransitPop(transitSchema.Clean)
+	default:
+		return model.WithTransit(targetRef, transitSchema.Clean)
+	}
+}
+
 func bootstrapCallbackHandler(cbSchema CallbackHandlerSchema, er *entityregistry.Registry) error {
 	return er.RegisterCallbackHandler(
 		model.NewCallbackHandler(
@@ -105,12 +125,79 @@ func bootstrapCallbackHandler(cbSchema CallbackHandlerSchema, er *entityregistry
 }
 
 func bootstrapCommand(cmdSchema CommandSchema, er *entityregistry.Registry) error {
-	return er.RegisterCommand(
-		model.NewCommand(
-			cmdSchema.Name,
-			model.ResourceRef(cmdSchema.ActionRef),
-		),
+	cmd, err := newCommand(cmdSchema)
+	if err != nil {
+		return err
+	}
+	return er.RegisterCommand(cmd)
+}
+
+func newCommand(cmdSchema CommandSchema) (*model.Command, error) {
+	cmd := model.NewCommand(
+		cmdSchema.Name,
+		model.ResourceRef(cmdSchema.ActionRef),
+		model.WithCommandDescription(cmdSchema.Description),
+		model.WithCommandUsage(cmdSchema.Usage),
+		model.WithCommandAliases(cmdSchema.Aliases...),
 	)
+	for lang, description := range cmdSchema.Descriptions {
+		cmd.WithLocalizedDescription(lang, description)
+	}
+	visibility, err := bootstrapCommandVisibility(cmdSchema.Visibility)
+	if err != nil {
+		return nil, err
+	}
+	cmd.WithVisibility(visibility)
+	for _, argSchema := range cmdSchema.Args {
+		argType, err := bootstrapArgType(argSchema.Type)
+		if err != nil {
+			return nil, err
+		}
+		cmd.WithArgs(&model.ArgSpec{
+			Name:     argSchema.Name,
+			Type:     argType,
+			Optional: argSchema.Optional,
+		})
+	}
+	return cmd, nil
+}
+
+func bootstrapCommandVisibility(visibility string) (model.CommandVisibility, error) {
+	switch visibility {
+	case "", "VISIBLE":
+		return model.VisibleCommand, nil
+	case "PRIVATE":
+		return model.PrivateChatCommand, nil
+	case "GROUP":
+		return model.GroupChatCommand, nil
+	case "ADMIN":
+		return model.AdminCommand, nil
+	case "HIDDEN":
+		return model.HiddenCommand, nil
+	}
+	return 0, fmt.Errorf("unknown command visibility %s", visibility)
+}
+
+func bootstrapArgType(argType string) (model.ArgType, error) {
+	switch argType {
+	case "", "STRING":
+		return model.StringArg, nil
+	case "INT":
+		return model.IntArg, nil
+	case "FLOAT":
+		return model.FloatArg, nil
+	case "BOOL":
+		return model.BoolArg, nil
+	case "DURATION":
+		return model.DurationArg, nil
+	case "TEXT":
+		return model.TextArg, nil
+	case "PAYLOAD":
+		return model.PayloadArg, nil
+	case "BASE64_PAYLOAD":
+		return model.Base64PayloadArg, nil
+	}
+	return 0, fmt.Errorf("unknown argument type %s", argType)
 }
 
 func bootstrapStage(stageSchema StageSchema, er *entityregistry.Registry) error {
@@ -121,29 +208,159 @@ func bootstrapStage(stageSchema StageSchema, er *entityregistry.Registry) error
 	)
 
 	if stageSchema.Initializer != nil {
-		initializerMessage := model.NewMessage(
-			model.WithText(stageSchema.Initializer.Message),
-		)
+		initializer, err := bootstrapStageInitializer(stageSchema.Initializer)
+		if err != nil {
+			return err
+		}
+		stage.WithInitializer(initializer)
+	}
 
-		if stageSchema.Initializer.Keyboard != nil {
-			var buttons []*model.ReplyButton
-			for _, buttonSchema := range stageSchema.Initializer.Keyboard.Buttons {
-				buttons = append(buttons, bootstrapReplyButton(buttonSchema))
-			}
-			keyboard := model.NewKeyboard[*model.ReplyButton](
-				bootstrapKeyboardLayout(stageSchema.Initializer.Keyboard.Layout),
-				buttons...,
-			)
-			initializerMessage.WithReplyKeyboard(keyboard)
+	for _, routeSchema := range stageSchema.InputRoutes {
+		route, err := bootstrapInputRoute(routeSchema)
+		if err != nil {
+			return err
 		}
+		stage.WithInputRoutes(route)
+	}
 
-		stage.WithInitializer(model.NewStaticStageInitializer(initializerMessage))
+	for _, cmdSchema := range stageSchema.Commands {
+		cmd, err := newCommand(cmdSchema)
+		if err != nil {
+			return err
+		}
+		stage.WithCommands(cmd)
+	}
+	policy, err := bootstrapCommandPolicy(stageSchema.CommandPolicy)
+	if err != nil {
+		return err
 	}
+	var allowed []model.ResourceRef
+	for _, cmdRef := range stageSchema.AllowedCommands {
+		allowed = append(allowed, model.ResourceRef(cmdRef))
+	}
+	stage.WithCommandPolicy(policy, allowed...)
 	return er.RegisterStage(stage)
 }
 
-func bootstrapReplyButton(buttonSchema InitializerKeyboardButtonSchema) *model.ReplyButton {
-	return model.NewReplyButton(buttonSchema.Name).LinkAction(model.ResourceRef(buttonSchema.ActionRef))
+func bootstrapCommandPolicy(policy string) (model.CommandPolicy, error) {
+	switch policy {
+	case "", "ALL":
+		return model.AllCommandsAllowed, nil
+	case "LISTED":
+		return model.ListedCommandsAllowed, nil
+	case "NONE":
+		return model.NoCommandsAllowed, nil
+	}
+	return 0, fmt.Errorf("unknown command policy %s", policy)
+}
+
+func bootstrapStageInitializer(initializerSchema *InitializerSchema) (model.StageInitializer, error) {
+	parseMode := bootstrapParseMode(initializerSchema.ParseMode)
+	var keyboard [][]*model.ReplyButton
+	if initializerSchema.Keyboard != nil {
+		var buttons []*model.ReplyButton
+		for _, buttonSchema := range initializerSchema.Keyboard.Buttons {
+			button, err := bootstrapReplyButton(buttonSchema)
+			if err != nil {
+				return nil, err
+			}
+			buttons = append(buttons, button)
+		}
+		var err error
+		keyboard, err = model.NewReplyKeyboard(
+			bootstrapKeyboardGrid(initializerSchema.Keyboard),
+			buttons...,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newMessage := func(text string) *model.Message {
+		message := model.NewMessage(
+			model.WithText(text),
+			model.WithParseMode(parseMode),
+		)
+		if keyboard != nil {
+			message.
+				WithReplyKeyboard(keyboard).
+				WithReplyKeyboardOptions(bootstrapReplyKeyboardOptions(initializerSchema.Keyboard))
+		}
+		return message
+	}
+
+	if !initializerSchema.Template {
+		return model.NewStaticStageInitializer(newMessage(initializerSchema.Message)), nil
+	}
+	return model.FuncStageInitializer(func(_ context.Context, userCtx *model.UserContext, _ model.ResourceRef) ([]*model.Message, error) {
+		text, err := executeUserTemplate(initializerSchema.Message, parseMode, userCtx)
+		if err != nil {
+			return nil, err
+		}
+		return []*model.Message{newMessage(text)}, nil
+	}), nil
+}
+
+func bootstrapInputRoute(routeSchema InputRouteSchema) (*model.InputRoute, error) {
+	actionRef := model.ResourceRef(routeSchema.ActionRef)
+	switch routeSchema.Type {
+	case "REGEX":
+		re, err := regexp.Compile(routeSchema.Value)
+		if err != nil {
+			return nil, err
+		}
+		return model.NewRegexInputRoute(re, actionRef), nil
+	case "PREFIX":
+		return model.NewPrefixInputRoute(routeSchema.Value, actionRef), nil
+	case "CONTENT_TYPE":
+		var types []model.ContentType
+		for _, t := range strings.Split(routeSchema.Value, ",") {
+			types = append(types, model.ContentType(strings.TrimSpace(t)))
+		}
+		return model.NewContentTypeInputRoute(actionRef, types...), nil
+	default:
+		return nil, fmt.Errorf("unknown input route type %s", routeSchema.Type)
+	}
+}
+
+func bootstrapReplyButton(buttonSchema InitializerKeyboardButtonSchema) (*model.ReplyButton, error) {
+	var button *model.ReplyButton
+	switch buttonSchema.Type {
+	case "", "TEXT":
+		button = model.NewReplyButton(buttonSchema.Name)
+	case "CONTACT":
+		button = model.NewContactButton(buttonSchema.Name)
+	case "LOCATION":
+		button = model.NewLocationButton(buttonSchema.Name)
+	case "POLL":
+		button = model.NewPollButton(buttonSchema.Name, model.PollType(strings.ToLower(buttonSchema.PollType)))
+	case "BACK":
+		return model.NewBackReplyButton(buttonSchema.Name), nil
+	default:
+		return nil, fmt.Errorf("unknown button type %s", buttonSchema.Type)
+	}
+	return button.LinkAction(model.ResourceRef(buttonSchema.ActionRef)), nil
+}
+
+func bootstrapReplyKeyboardOptions(keyboardSchema *InitializerKeyboardSchema) *model.ReplyKeyboardOptions {
+	resize := true
+	if keyboardSchema.Resize != nil {
+		resize = *keyboardSchema.Resize
+	}
+	return &model.ReplyKeyboardOptions{
+		Resize:      resize,
+		OneTime:     keyboardSchema.OneTime,
+		Persistent:  keyboardSchema.Persistent,
+		Placeholder: keyboardSchema.Placeholder,
+	}
+}
+
+func bootstrapKeyboardGrid(keyboardSchema *InitializerKeyboardSchema) model.KeyboardGrid {
+	layout := bootstrapKeyboardLayout(keyboardSchema.Layout)
+	if layout == model.Custom {
+		return model.NewCustomGrid(keyboardSchema.Rows...)
+	}
+	return model.NewGrid(layout)
 }
 
 func bootstrapKeyboardLayout(layout string) model.KeyboardLayout {
@@ -158,20 +375,20 @@ func bootstrapKeyboardLayout(layout string) model.KeyboardLayout {
 		return model.FourPerRow
 	case "FIVE_PER_ROW":
 		return model.FivePerRow
+	case "CUSTOM":
+		return model.Custom
 	default:
 		return model.OnePerRow
 	}
 }
 
-func executeUserTemplate(tplText string, data interface{}) (string, error) {
-	msgBuf := bytes.NewBuffer(nil)
-	tpl, err := template.New("").Parse(tplText)
-	if err != nil {
-		return "", err
-	}
-	err = tpl.Execute(msgBuf, data)
-	if err != nil {
-		return "", err
+func bootstrapParseMode(mode string) model.ParseMode {
+	switch mode {
+	case "HTML":
+		return model.HTML
+	case "MARKDOWN_V2":
+		return model.MarkdownV2
+	default:
+		return model.PlainText
 	}
-	return msgBuf.String(), nil
 }
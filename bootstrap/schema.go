@@ -9,9 +9,10 @@ type BotSchema struct {
 }
 
 type ActionSchema struct {
-	Name    string         `json:"name"`
-	Message string         `json:"message"`
-	Transit *TransitSchema `json:"transit,omitempty"`
+	Name      string         `json:"name"`
+	Message   string         `json:"message"`
+	ParseMode string         `json:"parse_mode,omitempty"`
+	Transit   *TransitSchema `json:"transit,omitempty"`
 }
 
 type CallbackHandlerSchema struct {
@@ -20,8 +21,23 @@ type CallbackHandlerSchema struct {
 }
 
 type CommandSchema struct {
-	Name      string `json:"name"`
-	ActionRef string `json:"action_ref"`
+	Name        string   `json:"name"`
+	ActionRef   string   `json:"action_ref"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Description string   `json:"description,omitempty"`
+	// Descriptions holds localized descriptions by language code.
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+	// Visibility is one of VISIBLE (default), PRIVATE, GROUP, ADMIN or HIDDEN.
+	Visibility string      `json:"visibility,omitempty"`
+	Args       []ArgSchema `json:"args,omitempty"`
+	Usage      string      `json:"usage,omitempty"`
+}
+
+type ArgSchema struct {
+	Name string `json:"name"`
+	// Type is one of STRING (default), INT, FLOAT, BOOL, DURATION, TEXT, PAYLOAD or BASE64_PAYLOAD.
+	Type     string `json:"type,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
 }
 
 type StageSchema struct {
@@ -29,21 +45,44 @@ type StageSchema struct {
 	DefaultActionRef string             `json:"default_action_ref,omitempty"`
 	InputAllowed     bool               `json:"input_allowed,omitempty"`
 	Initializer      *InitializerSchema `json:"initializer,omitempty"`
+	InputRoutes      []InputRouteSchema `json:"input_routes,omitempty"`
+	Commands         []CommandSchema    `json:"commands,omitempty"`
+	// CommandPolicy is one of ALL (default), LISTED or NONE, LISTED allows the
+	// registry commands of AllowedCommands.
+	CommandPolicy   string   `json:"command_policy,omitempty"`
+	AllowedCommands []string `json:"allowed_commands,omitempty"`
+}
+
+type InputRouteSchema struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	ActionRef string `json:"action_ref"`
 }
 
 type InitializerSchema struct {
-	Message  string                     `json:"message"`
+	Message   string `json:"message"`
+	ParseMode string `json:"parse_mode,omitempty"`
+	// Template renders Message with the user context on every stage initialization.
+	Template bool                       `json:"template,omitempty"`
 	Keyboard *InitializerKeyboardSchema `json:"keyboard"`
 }
 
 type InitializerKeyboardSchema struct {
-	Layout  string                            `json:"layout"`
-	Buttons []InitializerKeyboardButtonSchema `json:"buttons"`
+	Layout      string                            `json:"layout"`
+	Rows        []int                             `json:"rows,omitempty"`
+	Buttons     []InitializerKeyboardButtonSchema `json:"buttons"`
+	Resize      *bool                             `json:"resize,omitempty"`
+	OneTime     bool                              `json:"one_time,omitempty"`
+	Persistent  bool                              `json:"persistent,omitempty"`
+	Placeholder string                            `json:"placeholder,omitempty"`
 }
 
 type InitializerKeyboardButtonSchema struct {
 	Name      string `json:"name"`
 	ActionRef string `json:"action_ref"`
+	// Type is one of TEXT (default), CONTACT, LOCATION, POLL or BACK.
+	Type     string `json:"type,omitempty"`
+	PollType string `json:"poll_type,omitempty"`
 }
 
 type InitializerMessageSchema struct {
@@ -51,6 +90,8 @@ type InitializerMessageSchema struct {
 }
 
 type TransitSchema struct {
+	// Mode is one of PUSH (default), REPLACE or POP, target_ref is ignored for POP.
+	Mode      string `json:"mode,omitempty"`
 	TargetRef string `json:"target_ref"`
 	Clean     bool   `json:"clean"`
 }
@@ -0,0 +1,124 @@
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/atsegelnyk/galaxia/model"
+)
+
+const escapeFuncName = "escape"
+
+// formatFuncs produce markup themselves, pipelines ending with one of them are not escaped again.
+func formatFuncs(mode model.ParseMode) template.FuncMap {
+	return template.FuncMap{
+		escapeFuncName: func(v interface{}) string {
+			return model.Escape(mode, toString(v))
+		},
+		"raw": func(v interface{}) string {
+			return toString(v)
+		},
+		"bold": func(v interface{}) string {
+			return model.Bold(mode, toString(v))
+		},
+		"italic": func(v interface{}) string {
+			return model.Italic(mode, toString(v))
+		},
+		"underline": func(v interface{}) string {
+			return model.Underline(mode, toString(v))
+		},
+		"strike": func(v interface{}) string {
+			return model.Strikethrough(mode, toString(v))
+		},
+		"spoiler": func(v interface{}) string {
+			return model.Spoiler(mode, toString(v))
+		},
+		"code": func(v interface{}) string {
+			return model.Code(mode, toString(v))
+		},
+		"pre": func(v interface{}, language string) string {
+			return model.Pre(mode, toString(v), language)
+		},
+		"link": func(text interface{}, url string) string {
+			return model.Link(mode, toString(text), url)
+		},
+		"mention": func(text interface{}, userID int64) string {
+			return model.Mention(mode, toString(text), userID)
+		},
+	}
+}
+
+// executeUserTemplate renders a message template. For formatted parse modes every
+// interpolated value is escaped unless it went through one of the format functions.
+func executeUserTemplate(tplText string, mode model.ParseMode, data interface{}) (string, error) {
+	funcs := formatFuncs(mode)
+	tpl, err := template.New("").Funcs(funcs).Parse(tplText)
+	if err != nil {
+		return "", err
+	}
+	if mode != model.PlainText && tpl.Tree != nil {
+		autoEscape(tpl.Tree.Root, funcs)
+	}
+
+	msgBuf := bytes.NewBuffer(nil)
+	err = tpl.Execute(msgBuf, data)
+	if err != nil {
+		return "", err
+	}
+	return msgBuf.String(), nil
+}
+
+func autoEscape(node parse.Node, funcs template.FuncMap) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			autoEscape(child, funcs)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 || endsWithFormatFunc(n.Pipe, funcs) {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(escapeFuncName).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		autoEscape(n.List, funcs)
+		autoEscape(n.ElseList, funcs)
+	case *parse.RangeNode:
+		autoEscape(n.List, funcs)
+		autoEscape(n.ElseList, funcs)
+	case *parse.WithNode:
+		autoEscape(n.List, funcs)
+		autoEscape(n.ElseList, funcs)
+	}
+}
+
+func endsWithFormatFunc(pipe *parse.PipeNode, funcs template.FuncMap) bool {
+	if len(pipe.Cmds) == 0 {
+		return false
+	}
+	last := pipe.Cmds[len(pipe.Cmds)-1]
+	if len(last.Args) == 0 {
+		return false
+	}
+	ident, ok := last.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return false
+	}
+	_, ok = funcs[ident.Ident]
+	return ok
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,285 @@
+package galaxia
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/atsegelnyk/galaxia/model"
+	"github.com/atsegelnyk/galaxia/session"
+	"github.com/atsegelnyk/galaxia/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// commandCall is a command invocation parsed from a message.
+type commandCall struct {
+	name string
+	// bot is the username of the @bot suffix, empty without a suffix.
+	bot  string
+	args string
+	// entity is set when Telegram marked the command, which it does only for names
+	// made of latin letters, digits and underscores. Other slash words such as /?
+	// are commands only when they resolve to a known command.
+	entity bool
+}
+
+func parseCommand(msg *tgbotapi.Message) *commandCall {
+	var call *commandCall
+	switch {
+	case msg.IsCommand():
+		call = &commandCall{
+			name:   msg.CommandWithAt(),
+			args:   msg.CommandArguments(),
+			entity: true,
+		}
+	case strings.HasPrefix(msg.Text, "/"):
+		word, args := msg.Text[1:], ""
+		if i := strings.IndexFunc(word, unicode.IsSpace); i != -1 {
+			word, args = word[:i], strings.TrimLeftFunc(word[i:], unicode.IsSpace)
+		}
+		if word == "" {
+			return nil
+		}
+		call = &commandCall{
+			name: word,
+			args: args,
+		}
+	default:
+		return nil
+	}
+	if name, bot, found := strings.Cut(call.name, "@"); found {
+		call.name, call.bot = name, bot
+	}
+	return call
+}
+
+// addressedToBot drops commands suffixed with the username of another bot, which
+// group members use to pick one of several bots in the chat.
+func (p *Processor) addressedToBot(msg *tgbotapi.Message, call *commandCall) bool {
+	if call.bot == "" || !(msg.Chat.IsGroup() || msg.Chat.IsSuperGroup()) {
+		return true
+	}
+	return strings.EqualFold(call.bot, p.api.Self.UserName)
+}
+
+// isCommand reports whether call has to be handled as a command rather than input.
+func (p *Processor) isCommand(ses *session.Session, call *commandCall) bool {
+	if call == nil {
+		return false
+	}
+	if call.entity {
+		return true
+	}
+	_, err := p.resolveCommand(ses, call.name)
+	return err == nil || errors.Is(err, model.CommandNotAllowedError)
+}
+
+// currentStage returns the stage the user is in, or nil outside of any stage.
+func (p *Processor) currentStage(ses *session.Session) *model.Stage {
+	stageRef := ses.GetCurrentStage()
+	if stageRef.Empty() {
+		return nil
+	}
+	stg, err := p.entityRegistry.GetStage(ses.UserID, stageRef)
+	if err != nil {
+		return nil
+	}
+	return stg
+}
+
+// resolveCommand looks up a command of the current stage first, registry commands
+// are subject to the stage command policy. The start command is never blocked so the
+// user can't get stuck in a stage.
+func (p *Processor) resolveCommand(ses *session.Session, name string) (*model.Command, error) {
+	stg := p.currentStage(ses)
+	if stg != nil {
+		if cmd := stg.FindCommand(name, p.foldCommandCase); cmd != nil {
+			return cmd, nil
+		}
+	}
+	cmd, err := p.entityRegistry.FindCommand(ses.UserID, name, p.foldCommandCase)
+	if err != nil {
+		return nil, err
+	}
+	cmdRef := cmd.SelfRef()
+	if stg != nil && cmdRef != StartCMDName && !stg.CommandAllowed(cmdRef) {
+		return nil, fmt.Errorf("%w: %s in stage %s", model.CommandNotAllowedError, cmdRef, stg.SelfRef())
+	}
+	return cmd, nil
+}
+
+var defaultCommandScopes = []model.CommandScopeType{
+	model.DefaultCommandScope,
+	model.AllPrivateChatsCommandScope,
+	model.AllGroupChatsCommandScope,
+	model.AllChatAdministratorsCommandScope,
+}
+
+// publishCommands publishes the registry commands for the default, private and group
+// chat scopes, without a language code and for every language the commands have
+// descriptions for, followed by the chat menus of users with command overrides.
+func (p *Processor) publishCommands() error {
+	cmds := p.entityRegistry.ListCommands(0)
+	langs := commandLanguages(cmds)
+	for _, scopeType := range defaultCommandScopes {
+		var listed []*model.Command
+		for _, cmd := range cmds {
+			if cmd.ListedIn(scopeType) {
+				listed = append(listed, cmd)
+			}
+		}
+		for _, lang := range langs {
+			req := utils.TransformSetMyCommands(botCommands(listed, lang), model.NewCommandScope(scopeType), lang)
+			_, err := utils.Do(p.api, req)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, userID := range p.entityRegistry.CommandOverrideUsers() {
+		err := p.publishUserCommands(userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandsChanged republishes the menus affected by a registry change. It runs in the
+// background since commands are often overridden from within actions.
+func (p *Processor) commandsChanged(users ...int64) {
+	go func() {
+		if len(users) == 0 {
+			err := p.publishCommands()
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+		for _, userID := range users {
+			err := p.publishUserCommands(userID)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+}
+
+// publishUserCommands publishes the chat menu of a user with the current stage of the
+// user taken into account. The session is left as is, a stale menu digest only makes
+// the next update of the user publish the menu once more.
+func (p *Processor) publishUserCommands(userID int64) error {
+	ses, err := p.sessionRepository.Get(userID)
+	if err != nil {
+		if !errors.Is(err, session.NotFoundError) {
+			return err
+		}
+		ses = session.NewSession(userID)
+	}
+	return p.publishChatCommands(userID, p.chatCommandMenu(ses))
+}
+
+// chatCommandMenu lists the commands available to the user in the current stage with
+// descriptions in the user language. It is nil when neither the stage nor command
+// overrides of the user change the registry commands, the chat then shows the menus
+// of the broader scopes.
+func (p *Processor) chatCommandMenu(ses *session.Session) []model.BotCommand {
+	stg := p.currentStage(ses)
+	if (stg == nil || !stg.ScopesCommands()) && !p.entityRegistry.HasCommandOverrides(ses.UserID) {
+		return nil
+	}
+	var available []*model.Command
+	for _, cmd := range p.entityRegistry.ListCommands(ses.UserID) {
+		cmdRef := cmd.SelfRef()
+		if stg != nil && (stg.Command(cmdRef) != nil || cmdRef != StartCMDName && !stg.CommandAllowed(cmdRef)) {
+			continue
+		}
+		available = append(available, cmd)
+	}
+	if stg != nil {
+		available = append(available, stg.Commands()...)
+	}
+
+	scopeType := model.AllPrivateChatsCommandScope
+	if ses.UserID < 0 {
+		scopeType = model.AllGroupChatsCommandScope
+	}
+	cmds := make([]model.BotCommand, 0, len(available))
+	for _, cmd := range available {
+		if cmd.ListedIn(scopeType) {
+			cmds = append(cmds, cmd.BotCommand(ses.UserContext.Lang))
+		}
+	}
+	return cmds
+}
+
+// syncChatCommands publishes the command menu of the current stage for the chat of the
+// session. The menu is only sent when it differs from the last published one, failures
+// are logged since the update itself has been processed.
+func (p *Processor) syncChatCommands(ses *session.Session) {
+	if !p.commandMenuSync {
+		return
+	}
+	cmds := p.chatCommandMenu(ses)
+	digest := commandMenuDigest(cmds)
+	if digest == ses.CommandMenu {
+		return
+	}
+	err := p.publishChatCommands(ses.UserID, cmds)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ses.CommandMenu = digest
+}
+
+// publishChatCommands sets the menu of the chat, a nil menu drops it.
+func (p *Processor) publishChatCommands(chatID int64, cmds []model.BotCommand) error {
+	scope := model.NewChatCommandScope(chatID)
+	req := utils.TransformDeleteMyCommands(scope, "")
+	if cmds != nil {
+		req = utils.TransformSetMyCommands(cmds, scope, "")
+	}
+	_, err := utils.Do(p.api, req)
+	return err
+}
+
+func botCommands(cmds []*model.Command, lang string) []model.BotCommand {
+	botCmds := make([]model.BotCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		botCmds = append(botCmds, cmd.BotCommand(lang))
+	}
+	return botCmds
+}
+
+// commandLanguages returns an empty code for the default menus followed by the
+// two-letter codes, the only ones menus accept, commands have descriptions for.
+func commandLanguages(cmds []*model.Command) []string {
+	langs := []string{""}
+	seen := make(map[string]bool)
+	for _, cmd := range cmds {
+		for _, lang := range cmd.Languages() {
+			if len(lang) == 2 && !seen[lang] {
+				seen[lang] = true
+				langs = append(langs, lang)
+			}
+		}
+	}
+	return langs
+}
+
+// commandMenuDigest returns an empty digest for the default menu.
+func commandMenuDigest(cmds []model.BotCommand) string {
+	if cmds == nil {
+		return ""
+	}
+	h := fnv.New64a()
+	for _, cmd := range cmds {
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00", cmd.Command, cmd.Description)
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
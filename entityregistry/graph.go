@@ -0,0 +1,358 @@
+package entityregistry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/atsegelnyk/galaxia/model"
+)
+
+type NodeKind string
+
+const (
+	CommandNode         NodeKind = "command"
+	ActionNode          NodeKind = "action"
+	StageNode           NodeKind = "stage"
+	ButtonNode          NodeKind = "button"
+	CallbackHandlerNode NodeKind = "callback_handler"
+	// DynamicNode stands for whatever action code or a dynamic initializer decides at runtime.
+	DynamicNode NodeKind = "dynamic"
+)
+
+type Node struct {
+	ID    string
+	Kind  NodeKind
+	Ref   model.ResourceRef
+	Label string
+	// UserID is set for per-user overrides.
+	UserID int64
+	// Missing marks references to entities the registry doesn't have.
+	Missing bool
+}
+
+type Edge struct {
+	From  string
+	To    string
+	Label string
+	// Dynamic edges lead to DynamicNode or come from code, they can't be checked statically.
+	Dynamic bool
+}
+
+// Graph is the conversation flow as far as it is statically known: commands lead to
+// actions, actions to their declared transit targets, stages to the buttons of their
+// static initializers and to their input actions, buttons to actions and callback
+// handlers.
+type Graph struct {
+	Nodes []*Node
+	Edges []*Edge
+}
+
+// Graph builds the conversation graph of the registry including per-user overrides.
+func (r *Registry) Graph() *Graph {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	b := &graphBuilder{registry: r, graph: &Graph{}, nodes: make(map[string]*Node)}
+	b.addEntities(0, r.cmds, r.stages, r.callbackHandlers, r.actions)
+	for _, userID := range sortedUsers(r.overrides) {
+		uo := r.overrides[userID]
+		b.addEntities(userID, uo.cmds, uo.stages, uo.callbackHandlers, uo.actions)
+	}
+	return b.graph
+}
+
+type graphBuilder struct {
+	registry *Registry
+	graph    *Graph
+	nodes    map[string]*Node
+}
+
+func (b *graphBuilder) node(kind NodeKind, ref model.ResourceRef, userID int64, label string) *Node {
+	key := fmt.Sprintf("%s\x00%s\x00%d", kind, ref, userID)
+	if n, ok := b.nodes[key]; ok {
+		return n
+	}
+	n := &Node{
+		ID:     fmt.Sprintf("n%d", len(b.graph.Nodes)),
+		Kind:   kind,
+		Ref:    ref,
+		Label:  label,
+		UserID: userID,
+	}
+	if userID != 0 {
+		n.Label = fmt.Sprintf("%s (user %d)", label, userID)
+	}
+	b.nodes[key] = n
+	b.graph.Nodes = append(b.graph.Nodes, n)
+	return n
+}
+
+func (b *graphBuilder) edge(from, to *Node, label string, dynamic bool) {
+	b.graph.Edges = append(b.graph.Edges, &Edge{
+		From:    from.ID,
+		To:      to.ID,
+		Label:   label,
+		Dynamic: dynamic,
+	})
+}
+
+func (b *graphBuilder) dynamicNode() *Node {
+	return b.node(DynamicNode, "", 0, "dynamic")
+}
+
+// The *Ref methods return the node a reference made in the scope of userID resolves to.
+
+func (b *graphBuilder) actionRef(userID int64, ref model.ResourceRef) *Node {
+	if _, ok := b.registry.overrides[userID].actions[ref]; ok {
+		return b.node(ActionNode, ref, userID, string(ref))
+	}
+	n := b.node(ActionNode, ref, 0, string(ref))
+	_, ok := b.registry.actions[ref]
+	n.Missing = !ok
+	return n
+}
+
+func (b *graphBuilder) stageRef(userID int64, ref model.ResourceRef) *Node {
+	if _, ok := b.registry.overrides[userID].stages[ref]; ok {
+		return b.node(StageNode, ref, userID, string(ref))
+	}
+	n := b.node(StageNode, ref, 0, string(ref))
+	_, ok := b.registry.stages[ref]
+	n.Missing = !ok
+	return n
+}
+
+func (b *graphBuilder) callbackHandlerRef(userID int64, ref model.ResourceRef) *Node {
+	if _, ok := b.registry.overrides[userID].callbackHandlers[ref]; ok {
+		return b.node(CallbackHandlerNode, ref, userID, string(ref))
+	}
+	n := b.node(CallbackHandlerNode, ref, 0, string(ref))
+	_, ok := b.registry.callbackHandlers[ref]
+	n.Missing = !ok
+	return n
+}
+
+func (b *graphBuilder) addEntities(
+	userID int64,
+	cmds map[model.ResourceRef]*model.Command,
+	stages map[model.ResourceRef]*model.Stage,
+	callbackHandlers map[model.ResourceRef]*model.CallbackHandler,
+	actions map[model.ResourceRef]*model.Action,
+) {
+	for _, ref := range sortedRefs(cmds) {
+		b.addCommand(userID, cmds[ref], ref)
+	}
+	for _, ref := range sortedRefs(callbackHandlers) {
+		handler := b.node(CallbackHandlerNode, ref, userID, string(ref))
+		b.edge(handler, b.actionRef(userID, callbackHandlers[ref].ActionRef()), "", false)
+	}
+	for _, ref := range sortedRefs(actions) {
+		b.addAction(userID, actions[ref])
+	}
+	for _, ref := range sortedRefs(stages) {
+		b.addStage(userID, stages[ref])
+	}
+}
+
+// addCommand adds a command under key, stage commands are keyed by their stage.
+func (b *graphBuilder) addCommand(userID int64, cmd *model.Command, key model.ResourceRef) *Node {
+	n := b.node(CommandNode, key, userID, "/"+string(cmd.SelfRef()))
+	b.edge(n, b.actionRef(userID, cmd.ActionRef()), "", false)
+	return n
+}
+
+// addAction links an action to its declared transit targets. Actions that didn't
+// declare their transits lead to the dynamic node, the back action pops the stage history.
+func (b *graphBuilder) addAction(userID int64, act *model.Action) {
+	n := b.node(ActionNode, act.SelfRef(), userID, string(act.SelfRef()))
+	switch {
+	case act.SelfRef() == model.BackActionRef:
+		b.edge(n, b.dynamicNode(), "back", true)
+		return
+	case !act.TransitsDeclared():
+		b.edge(n, b.dynamicNode(), "", true)
+		return
+	}
+	for _, target := range act.TransitTargets() {
+		b.edge(n, b.stageRef(userID, target), "transit", false)
+	}
+}
+
+func (b *graphBuilder) addStage(userID int64, stg *model.Stage) {
+	stageRef := stg.SelfRef()
+	n := b.node(StageNode, stageRef, userID, string(stageRef))
+
+	if !stg.DefaultActionRef().Empty() {
+		b.edge(n, b.actionRef(userID, stg.DefaultActionRef()), "input", false)
+	}
+	for _, route := range stg.InputRoutes() {
+		b.edge(n, b.actionRef(userID, route.ActionRef()), "route", false)
+	}
+	if validation := stg.InputValidation(); validation != nil && !validation.EscalationRef.Empty() {
+		b.edge(n, b.actionRef(userID, validation.EscalationRef), "escalation", false)
+	}
+	for _, cmd := range stg.Commands() {
+		key := stageRef + "/" + cmd.SelfRef()
+		b.edge(n, b.addCommand(userID, cmd, key), "command", false)
+	}
+	for i, timer := range stg.Timers() {
+		label := "after " + timer.After.String()
+		switch {
+		case !timer.ActionRef.Empty():
+			b.edge(n, b.actionRef(userID, timer.ActionRef), label, false)
+		case timer.Transit != nil && timer.Transit.Mode == model.TransitPop:
+			b.edge(n, b.dynamicNode(), label, true)
+		case timer.Transit != nil:
+			b.edge(n, b.stageRef(userID, timer.Transit.TargetRef), label, false)
+		}
+		b.addButtons(userID, n, fmt.Sprintf("%s/timer%d", stageRef, i), timer.Messages)
+	}
+
+	switch initializer := stg.Initializer().(type) {
+	case nil:
+	case *model.StaticStageInitializer:
+		b.addButtons(userID, n, string(stageRef), initializer.Messages)
+	default:
+		b.edge(n, b.dynamicNode(), "initializer", true)
+	}
+}
+
+func (b *graphBuilder) addButtons(userID int64, from *Node, keyPrefix string, msgs []*model.Message) {
+	i := 0
+	key := func() model.ResourceRef {
+		i++
+		return model.ResourceRef(fmt.Sprintf("%s/button%d", keyPrefix, i))
+	}
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		for _, row := range msg.ReplyKeyboard {
+			for _, button := range row {
+				n := b.node(ButtonNode, key(), userID, button.Text)
+				b.edge(from, n, "", false)
+				if !button.ActionRef.Empty() {
+					b.edge(n, b.actionRef(userID, button.ActionRef), "", false)
+				}
+			}
+		}
+		var inlineButtons []*model.InlineButton
+		for _, row := range msg.InlineKeyboard {
+			inlineButtons = append(inlineButtons, row...)
+		}
+		if msg.MultiPageKeyboard != nil {
+			inlineButtons = append(inlineButtons, msg.MultiPageKeyboard.AllButtons...)
+		}
+		for _, button := range inlineButtons {
+			if !button.IsCallback() {
+				continue
+			}
+			n := b.node(ButtonNode, key(), userID, button.Text)
+			b.edge(from, n, "", false)
+			if !button.CallbackHandlerRef.Empty() {
+				b.edge(n, b.callbackHandlerRef(userID, button.CallbackHandlerRef), "", false)
+			}
+		}
+	}
+}
+
+var mermaidShapes = map[NodeKind][2]string{
+	CommandNode:         {"([", "])"},
+	ActionNode:          {"[", "]"},
+	StageNode:           {"[[", "]]"},
+	ButtonNode:          {"[/", "/]"},
+	CallbackHandlerNode: {"{{", "}}"},
+	DynamicNode:         {"((", "))"},
+}
+
+// Mermaid renders the graph as a Mermaid flowchart. Overrides are drawn with dashed
+// borders, missing entities in red and dynamic edges dotted.
+func (g *Graph) Mermaid() string {
+	var sb strings.Builder
+	sb.WriteString("flowchart LR\n")
+	var overrides, missing []string
+	for _, n := range g.Nodes {
+		shape := mermaidShapes[n.Kind]
+		fmt.Fprintf(&sb, "\t%s%s\"%s\"%s\n", n.ID, shape[0], mermaidEscape(n.Label), shape[1])
+		if n.UserID != 0 {
+			overrides = append(overrides, n.ID)
+		}
+		if n.Missing {
+			missing = append(missing, n.ID)
+		}
+	}
+	for _, e := range g.Edges {
+		arrow := "-->"
+		if e.Dynamic {
+			arrow = "-.->"
+		}
+		if e.Label != "" {
+			fmt.Fprintf(&sb, "\t%s %s|\"%s\"| %s\n", e.From, arrow, mermaidEscape(e.Label), e.To)
+		} else {
+			fmt.Fprintf(&sb, "\t%s %s %s\n", e.From, arrow, e.To)
+		}
+	}
+	sb.WriteString("\tclassDef override stroke-dasharray: 5 5\n")
+	sb.WriteString("\tclassDef missing stroke:#d00,color:#d00\n")
+	if len(overrides) > 0 {
+		fmt.Fprintf(&sb, "\tclass %s override\n", strings.Join(overrides, ","))
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(&sb, "\tclass %s missing\n", strings.Join(missing, ","))
+	}
+	return sb.String()
+}
+
+var dotShapes = map[NodeKind]string{
+	CommandNode:         "oval",
+	ActionNode:          "box",
+	StageNode:           "box3d",
+	ButtonNode:          "parallelogram",
+	CallbackHandlerNode: "hexagon",
+	DynamicNode:         "circle",
+}
+
+// DOT renders the graph in the Graphviz DOT language with the same conventions as Mermaid.
+func (g *Graph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph galaxia {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		attrs := []string{
+			fmt.Sprintf("label=%s", dotQuote(n.Label)),
+			"shape=" + dotShapes[n.Kind],
+		}
+		if n.UserID != 0 {
+			attrs = append(attrs, "style=dashed")
+		}
+		if n.Missing {
+			attrs = append(attrs, "color=red", "fontcolor=red")
+		}
+		fmt.Fprintf(&sb, "\t%s [%s];\n", n.ID, strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		if e.Label != "" {
+			attrs = append(attrs, "label="+dotQuote(e.Label))
+		}
+		if e.Dynamic {
+			attrs = append(attrs, "style=dotted")
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(&sb, "\t%s -> %s [%s];\n", e.From, e.To, strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(&sb, "\t%s -> %s;\n", e.From, e.To)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "\n", " ")
+
+func mermaidEscape(s string) string {
+	return mermaidEscaper.Replace(s)
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
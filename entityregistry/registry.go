@@ -1,13 +1,21 @@
 package entityregistry
 
 import (
+	"errors"
 	"fmt"
 	"github.com/atsegelnyk/galaxia/model"
+	"sort"
 	"sync"
 )
 
+var (
+	NotFoundError      = errors.New("entity not found")
+	AlreadyExistsError = errors.New("entity already exists")
+	ReservedError      = errors.New("entity is built in")
+)
+
 type Registry struct {
-	mu sync.Mutex
+	mu sync.RWMutex
 
 	actions          map[model.ResourceRef]*model.Action
 	cmds             map[model.ResourceRef]*model.Command
@@ -15,8 +23,14 @@ type Registry struct {
 	callbackHandlers map[model.ResourceRef]*model.CallbackHandler
 
 	overrides map[int64]userOverrides
+
+	commandListeners []CommandListener
 }
 
+// CommandListener is notified after commands change, users lists the users whose
+// command overrides changed and is empty for changes affecting everyone.
+type CommandListener func(users ...int64)
+
 type userOverrides struct {
 	actions          map[model.ResourceRef]*model.Action
 	cmds             map[model.ResourceRef]*model.Command
@@ -24,175 +38,427 @@ type userOverrides struct {
 	callbackHandlers map[model.ResourceRef]*model.CallbackHandler
 }
 
+func (uo userOverrides) empty() bool {
+	return len(uo.actions) == 0 && len(uo.cmds) == 0 && len(uo.stages) == 0 && len(uo.callbackHandlers) == 0
+}
+
+// Overrides is a snapshot of the entities overridden for a user, ordered by name.
+type Overrides struct {
+	Actions          []*model.Action
+	Commands         []*model.Command
+	Stages           []*model.Stage
+	CallbackHandlers []*model.CallbackHandler
+}
+
+// New returns a registry holding the built-in back action and callback handler.
 func New() *Registry {
 	entityRegistry := &Registry{
-		mu:               sync.Mutex{},
 		actions:          make(map[model.ResourceRef]*model.Action),
 		cmds:             make(map[model.ResourceRef]*model.Command),
 		stages:           make(map[model.ResourceRef]*model.Stage),
 		callbackHandlers: make(map[model.ResourceRef]*model.CallbackHandler),
 		overrides:        make(map[int64]userOverrides),
 	}
+	backAction := model.NewBackAction()
+	backHandler := model.NewBackCallbackHandler()
+	entityRegistry.actions[backAction.SelfRef()] = backAction
+	entityRegistry.callbackHandlers[backHandler.SelfRef()] = backHandler
 	return entityRegistry
 }
 
 func (r *Registry) RegisterCommand(cmd *model.Command) error {
-	if _, ok := r.cmds[cmd.SelfRef()]; ok {
-		return fmt.Errorf("command %s already exists", cmd.SelfRef())
+	err := register(r, r.cmds, cmd, "command")
+	if err != nil {
+		return err
 	}
-	r.mu.Lock()
-	r.cmds[cmd.SelfRef()] = cmd
-	r.mu.Unlock()
+	r.notifyCommandListeners()
 	return nil
 }
 
 func (r *Registry) RegisterStage(stg *model.Stage) error {
-	if _, ok := r.stages[stg.SelfRef()]; ok {
-		return fmt.Errorf("stage %s already exists", stg.SelfRef())
-	}
+	return register(r, r.stages, stg, "stage")
+}
+
+func (r *Registry) RegisterCallbackHandler(handler *model.CallbackHandler) error {
+	return register(r, r.callbackHandlers, handler, "callback handler")
+}
+
+func (r *Registry) RegisterAction(act *model.Action) error {
+	return register(r, r.actions, act, "action")
+}
+
+func register[T model.Referencer](r *Registry, entities map[model.ResourceRef]T, entity T, kind string) error {
 	r.mu.Lock()
-	r.stages[stg.SelfRef()] = stg
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+	if _, ok := entities[entity.SelfRef()]; ok {
+		return fmt.Errorf("%w: %s %s", AlreadyExistsError, kind, entity.SelfRef())
+	}
+	entities[entity.SelfRef()] = entity
 	return nil
 }
 
-func (r *Registry) RegisterCallbackHandler(handler *model.CallbackHandler) error {
-	if _, ok := r.callbackHandlers[handler.SelfRef()]; ok {
-		return fmt.Errorf("callback handler %s already exists", handler.SelfRef())
+// UnregisterCommand removes the command for everyone, overrides of it stay in place
+// until removed with RemoveCommandOverride or ClearOverrides.
+func (r *Registry) UnregisterCommand(cmdRef model.ResourceRef) error {
+	err := unregister(r, r.cmds, cmdRef, "command")
+	if err != nil {
+		return err
 	}
-	r.mu.Lock()
-	r.callbackHandlers[handler.SelfRef()] = handler
-	r.mu.Unlock()
+	r.notifyCommandListeners()
 	return nil
 }
 
-func (r *Registry) RegisterAction(act *model.Action) error {
-	if _, ok := r.actions[act.SelfRef()]; ok {
-		return fmt.Errorf("action %s already exists", act.SelfRef())
+func (r *Registry) UnregisterStage(stageRef model.ResourceRef) error {
+	return unregister(r, r.stages, stageRef, "stage")
+}
+
+// UnregisterCallbackHandler refuses to remove the built-in back handler, back buttons
+// of every stage rely on it. The same goes for the back action.
+func (r *Registry) UnregisterCallbackHandler(callbackRef model.ResourceRef) error {
+	if callbackRef == model.BackActionRef {
+		return fmt.Errorf("%w: callback handler %s", ReservedError, callbackRef)
+	}
+	return unregister(r, r.callbackHandlers, callbackRef, "callback handler")
+}
+
+func (r *Registry) UnregisterAction(actionRef model.ResourceRef) error {
+	if actionRef == model.BackActionRef {
+		return fmt.Errorf("%w: action %s", ReservedError, actionRef)
 	}
+	return unregister(r, r.actions, actionRef, "action")
+}
+
+func unregister[T any](r *Registry, entities map[model.ResourceRef]T, ref model.ResourceRef, kind string) error {
 	r.mu.Lock()
-	r.actions[act.SelfRef()] = act
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+	if _, ok := entities[ref]; !ok {
+		return fmt.Errorf("%w: %s %s", NotFoundError, kind, ref)
+	}
+	delete(entities, ref)
 	return nil
 }
 
+// OverrideCommand replaces the command for the given users, or for everyone when no
+// users are given.
 func (r *Registry) OverrideCommand(cmd *model.Command, users ...int64) {
+	r.mu.Lock()
 	if len(users) == 0 {
-		r.mu.Lock()
 		r.cmds[cmd.SelfRef()] = cmd
-		r.mu.Unlock()
-		return
 	}
-
 	for _, user := range users {
-		r.checkInitUserOverrides(user)
-		uo := r.overrides[user]
-		uo.cmds[cmd.SelfRef()] = cmd
+		r.userOverrides(user).cmds[cmd.SelfRef()] = cmd
 	}
+	r.mu.Unlock()
+	r.notifyCommandListeners(users...)
 }
 
 func (r *Registry) OverrideStage(stg *model.Stage, users ...int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if len(users) == 0 {
-		r.mu.Lock()
 		r.stages[stg.SelfRef()] = stg
-		r.mu.Unlock()
-		return
 	}
-
 	for _, user := range users {
-		r.checkInitUserOverrides(user)
-		uo := r.overrides[user]
-		uo.stages[stg.SelfRef()] = stg
+		r.userOverrides(user).stages[stg.SelfRef()] = stg
 	}
 }
 
 func (r *Registry) OverrideCallbackHandler(handler *model.CallbackHandler, users ...int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if len(users) == 0 {
-		r.mu.Lock()
 		r.callbackHandlers[handler.SelfRef()] = handler
-		r.mu.Unlock()
-		return
 	}
-
 	for _, user := range users {
-		r.checkInitUserOverrides(user)
-		uo := r.overrides[user]
-		uo.callbackHandlers[handler.SelfRef()] = handler
+		r.userOverrides(user).callbackHandlers[handler.SelfRef()] = handler
 	}
 }
 
 func (r *Registry) OverrideAction(act *model.Action, users ...int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if len(users) == 0 {
-		r.mu.Lock()
 		r.actions[act.SelfRef()] = act
-		r.mu.Unlock()
-		return
 	}
 	for _, user := range users {
-		r.checkInitUserOverrides(user)
-		uo := r.overrides[user]
-		uo.actions[act.SelfRef()] = act
+		r.userOverrides(user).actions[act.SelfRef()] = act
 	}
 }
 
-func (r *Registry) GetCommand(userID int64, cmdRef model.ResourceRef) (*model.Command, error) {
-	if override, ok := r.overrides[userID]; ok {
-		if cmd, overrideOk := override.cmds[cmdRef]; overrideOk {
-			return cmd, nil
+// RemoveCommandOverride drops the override of the command for the given users, who
+// get the registry command back.
+func (r *Registry) RemoveCommandOverride(cmdRef model.ResourceRef, users ...int64) {
+	r.removeOverrides(users, func(uo userOverrides) {
+		delete(uo.cmds, cmdRef)
+	})
+	r.notifyCommandListeners(users...)
+}
+
+func (r *Registry) RemoveStageOverride(stageRef model.ResourceRef, users ...int64) {
+	r.removeOverrides(users, func(uo userOverrides) {
+		delete(uo.stages, stageRef)
+	})
+}
+
+func (r *Registry) RemoveCallbackHandlerOverride(callbackRef model.ResourceRef, users ...int64) {
+	r.removeOverrides(users, func(uo userOverrides) {
+		delete(uo.callbackHandlers, callbackRef)
+	})
+}
+
+func (r *Registry) RemoveActionOverride(actionRef model.ResourceRef, users ...int64) {
+	r.removeOverrides(users, func(uo userOverrides) {
+		delete(uo.actions, actionRef)
+	})
+}
+
+func (r *Registry) removeOverrides(users []int64, remove func(uo userOverrides)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range users {
+		uo, ok := r.overrides[user]
+		if !ok {
+			continue
 		}
+		remove(uo)
+		if uo.empty() {
+			delete(r.overrides, user)
+		}
+	}
+}
+
+// ClearOverrides drops every override of the user.
+func (r *Registry) ClearOverrides(userID int64) {
+	r.mu.Lock()
+	uo, ok := r.overrides[userID]
+	delete(r.overrides, userID)
+	r.mu.Unlock()
+	if ok && len(uo.cmds) > 0 {
+		r.notifyCommandListeners(userID)
 	}
-	if cmd, ok := r.cmds[cmdRef]; ok {
+}
+
+// OnCommandsChanged registers a listener run after commands are registered,
+// overridden or removed.
+func (r *Registry) OnCommandsChanged(listener CommandListener) {
+	r.mu.Lock()
+	r.commandListeners = append(r.commandListeners, listener)
+	r.mu.Unlock()
+}
+
+func (r *Registry) notifyCommandListeners(users ...int64) {
+	r.mu.RLock()
+	listeners := r.commandListeners
+	r.mu.RUnlock()
+	for _, listener := range listeners {
+		listener(users...)
+	}
+}
+
+func (r *Registry) GetCommand(userID int64, cmdRef model.ResourceRef) (*model.Command, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if cmd, ok := r.lookupCommand(userID, cmdRef); ok {
 		return cmd, nil
 	}
-	return nil, fmt.Errorf("cmd %v not found", cmdRef)
+	return nil, fmt.Errorf("%w: cmd %v", NotFoundError, cmdRef)
 }
 
-func (r *Registry) GetStage(userID int64, stageRef model.ResourceRef) (*model.Stage, error) {
-	if override, ok := r.overrides[userID]; ok {
-		if stg, overrideOk := override.stages[stageRef]; overrideOk {
-			return stg, nil
+// FindCommand resolves a command by name or alias, exact names win over aliases and
+// case-insensitive matches.
+func (r *Registry) FindCommand(userID int64, name string, foldCase bool) (*model.Command, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if cmd, ok := r.lookupCommand(userID, model.ResourceRef(name)); ok {
+		return cmd, nil
+	}
+	for _, cmd := range mergeOverrides(r.cmds, r.overrides[userID].cmds) {
+		if cmd.Matches(name, foldCase) {
+			return cmd, nil
 		}
 	}
-	if stg, ok := r.stages[stageRef]; ok {
+	return nil, fmt.Errorf("%w: cmd %v", NotFoundError, name)
+}
+
+func (r *Registry) GetStage(userID int64, stageRef model.ResourceRef) (*model.Stage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if stg, ok := r.lookupStage(userID, stageRef); ok {
 		return stg, nil
 	}
-	return nil, fmt.Errorf("stage %v not found", stageRef)
+	return nil, fmt.Errorf("%w: stage %v", NotFoundError, stageRef)
 }
 
 func (r *Registry) GetCallbackHandler(userID int64, callbackRef model.ResourceRef) (*model.CallbackHandler, error) {
-	if override, ok := r.overrides[userID]; ok {
-		if cb, overrideOk := override.callbackHandlers[callbackRef]; overrideOk {
-			return cb, nil
-		}
-	}
-	if cb, ok := r.callbackHandlers[callbackRef]; ok {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if cb, ok := r.lookupCallbackHandler(userID, callbackRef); ok {
 		return cb, nil
 	}
-	return nil, fmt.Errorf("callback handler %v not found", callbackRef)
+	return nil, fmt.Errorf("%w: callback handler %v", NotFoundError, callbackRef)
 }
 
 func (r *Registry) GetAction(userID int64, actionRef model.ResourceRef) (*model.Action, error) {
-	if override, ok := r.overrides[userID]; ok {
-		if act, overrideOk := override.actions[actionRef]; overrideOk {
-			return act, nil
-		}
-	}
-	if act, ok := r.actions[actionRef]; ok {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if act, ok := r.lookupAction(userID, actionRef); ok {
 		return act, nil
 	}
-	return nil, fmt.Errorf("action %s not found", actionRef)
+	return nil, fmt.Errorf("%w: action %s", NotFoundError, actionRef)
+}
+
+// ListCommands returns the commands visible to the user, with the user overrides
+// applied, ordered by name. Pass 0 to list the registry commands alone, the same
+// goes for the other List* methods.
+func (r *Registry) ListCommands(userID int64) []*model.Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return mergeOverrides(r.cmds, r.overrides[userID].cmds)
+}
+
+func (r *Registry) ListStages(userID int64) []*model.Stage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return mergeOverrides(r.stages, r.overrides[userID].stages)
+}
+
+func (r *Registry) ListCallbackHandlers(userID int64) []*model.CallbackHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return mergeOverrides(r.callbackHandlers, r.overrides[userID].callbackHandlers)
+}
+
+func (r *Registry) ListActions(userID int64) []*model.Action {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return mergeOverrides(r.actions, r.overrides[userID].actions)
 }
 
-func (r *Registry) checkInitUserOverrides(userID int64) {
-	if _, ok := r.overrides[userID]; ok {
-		return
+// ListOverrides returns the entities overridden for the user alone.
+func (r *Registry) ListOverrides(userID int64) Overrides {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	uo := r.overrides[userID]
+	return Overrides{
+		Actions:          mergeOverrides(uo.actions, nil),
+		Commands:         mergeOverrides(uo.cmds, nil),
+		Stages:           mergeOverrides(uo.stages, nil),
+		CallbackHandlers: mergeOverrides(uo.callbackHandlers, nil),
+	}
+}
+
+// OverriddenUsers returns the users having overrides of any kind.
+func (r *Registry) OverriddenUsers() []int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sortedUsers(r.overrides)
+}
+
+// HasCommandOverrides reports whether the user has commands of their own.
+func (r *Registry) HasCommandOverrides(userID int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.overrides[userID].cmds) > 0
+}
+
+// CommandOverrideUsers returns the users having commands of their own.
+func (r *Registry) CommandOverrideUsers() []int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var users []int64
+	for _, userID := range sortedUsers(r.overrides) {
+		if len(r.overrides[userID].cmds) > 0 {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
+
+// userOverrides returns the overrides of the user, initializing them on first use.
+// The caller holds the write lock.
+func (r *Registry) userOverrides(userID int64) userOverrides {
+	if uo, ok := r.overrides[userID]; ok {
+		return uo
 	}
 	uo := userOverrides{
+		actions:          make(map[model.ResourceRef]*model.Action),
 		cmds:             make(map[model.ResourceRef]*model.Command),
 		stages:           make(map[model.ResourceRef]*model.Stage),
 		callbackHandlers: make(map[model.ResourceRef]*model.CallbackHandler),
 	}
-	r.mu.Lock()
 	r.overrides[userID] = uo
-	r.mu.Unlock()
+	return uo
+}
+
+// The lookup* methods resolve references like the Get* methods for callers already
+// holding the lock.
+
+func (r *Registry) lookupCommand(userID int64, ref model.ResourceRef) (*model.Command, bool) {
+	if cmd, ok := r.overrides[userID].cmds[ref]; ok {
+		return cmd, true
+	}
+	cmd, ok := r.cmds[ref]
+	return cmd, ok
+}
+
+func (r *Registry) lookupStage(userID int64, ref model.ResourceRef) (*model.Stage, bool) {
+	if stg, ok := r.overrides[userID].stages[ref]; ok {
+		return stg, true
+	}
+	stg, ok := r.stages[ref]
+	return stg, ok
+}
+
+func (r *Registry) lookupCallbackHandler(userID int64, ref model.ResourceRef) (*model.CallbackHandler, bool) {
+	if cb, ok := r.overrides[userID].callbackHandlers[ref]; ok {
+		return cb, true
+	}
+	cb, ok := r.callbackHandlers[ref]
+	return cb, ok
+}
+
+func (r *Registry) lookupAction(userID int64, ref model.ResourceRef) (*model.Action, bool) {
+	if act, ok := r.overrides[userID].actions[ref]; ok {
+		return act, true
+	}
+	act, ok := r.actions[ref]
+	return act, ok
+}
+
+// mergeOverrides applies the overrides to the entities and orders the result by name.
+func mergeOverrides[T any](entities, overrides map[model.ResourceRef]T) []T {
+	merged := make(map[model.ResourceRef]T, len(entities)+len(overrides))
+	for ref, entity := range entities {
+		merged[ref] = entity
+	}
+	for ref, entity := range overrides {
+		merged[ref] = entity
+	}
+	list := make([]T, 0, len(merged))
+	for _, ref := range sortedRefs(merged) {
+		list = append(list, merged[ref])
+	}
+	return list
+}
+
+func sortedRefs[T any](entities map[model.ResourceRef]T) []model.ResourceRef {
+	refs := make([]model.ResourceRef, 0, len(entities))
+	for ref := range entities {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i] < refs[j]
+	})
+	return refs
+}
+
+func sortedUsers(overrides map[int64]userOverrides) []int64 {
+	users := make([]int64, 0, len(overrides))
+	for userID := range overrides {
+		users = append(users, userID)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i] < users[j]
+	})
+	return users
 }
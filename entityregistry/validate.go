@@ -0,0 +1,239 @@
+package entityregistry
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/atsegelnyk/galaxia/model"
+)
+
+var InvalidRegistryError = errors.New("invalid registry")
+
+type IssueKind string
+
+const (
+	DanglingRefIssue          IssueKind = "dangling reference"
+	UnreachableStageIssue     IssueKind = "unreachable stage"
+	DuplicateButtonIssue      IssueKind = "duplicate button text"
+	MissingDefaultActionIssue IssueKind = "missing default action"
+)
+
+// Issue is a problem found by Validate. UserID is set for problems of per-user overrides.
+type Issue struct {
+	Kind    IssueKind
+	UserID  int64
+	Entity  string
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.UserID != 0 {
+		return fmt.Sprintf("%s: %s (user %d): %s", i.Kind, i.Entity, i.UserID, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Kind, i.Entity, i.Message)
+}
+
+// ValidationError lists every issue found by Validate, it wraps InvalidRegistryError.
+type ValidationError struct {
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Issues)+1)
+	lines = append(lines, fmt.Sprintf("%s: %d issues", InvalidRegistryError, len(e.Issues)))
+	for _, issue := range e.Issues {
+		lines = append(lines, "\t"+issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return InvalidRegistryError
+}
+
+// Validate walks all entities, per-user overrides and static stage initializers and
+// returns a *ValidationError listing dangling references, unreachable stages, duplicate
+// button texts within a keyboard and stages accepting custom input without a default
+// action. References in action code are invisible to it, actions declare the stages
+// they move the user to with WithTransitTargets. Unreachable stages, the ones no
+// declared transit or timer leads to, are only reported when every action declared
+// its transits, otherwise any stage may be entered from code and the skipped check is
+// logged with the actions to declare.
+func (r *Registry) Validate() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	v := &validator{registry: r, reachable: make(map[model.ResourceRef]bool)}
+	v.validateEntities(0, r.cmds, r.stages, r.callbackHandlers, r.actions)
+	for _, userID := range sortedUsers(r.overrides) {
+		uo := r.overrides[userID]
+		v.validateEntities(userID, uo.cmds, uo.stages, uo.callbackHandlers, uo.actions)
+	}
+
+	if len(v.undeclared) == 0 {
+		for _, stageRef := range sortedRefs(r.stages) {
+			if !v.reachable[stageRef] {
+				v.report(UnreachableStageIssue, 0, "stage "+string(stageRef), "no declared transit leads to it")
+			}
+		}
+	} else {
+		log.Printf("unreachable stages not checked, transits of %s are not declared", strings.Join(v.undeclared, ", "))
+	}
+	if len(v.issues) == 0 {
+		return nil
+	}
+	return &ValidationError{Issues: v.issues}
+}
+
+type validator struct {
+	registry   *Registry
+	reachable  map[model.ResourceRef]bool
+	undeclared []string
+	issues     []Issue
+}
+
+func (v *validator) report(kind IssueKind, userID int64, entity, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{
+		Kind:    kind,
+		UserID:  userID,
+		Entity:  entity,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validateEntities(
+	userID int64,
+	cmds map[model.ResourceRef]*model.Command,
+	stages map[model.ResourceRef]*model.Stage,
+	callbackHandlers map[model.ResourceRef]*model.CallbackHandler,
+	actions map[model.ResourceRef]*model.Action,
+) {
+	for _, ref := range sortedRefs(cmds) {
+		v.checkCommand(userID, "command "+string(ref), cmds[ref])
+	}
+	for _, ref := range sortedRefs(callbackHandlers) {
+		v.checkAction(userID, "callback handler "+string(ref), callbackHandlers[ref].ActionRef())
+	}
+	for _, ref := range sortedRefs(actions) {
+		if !actions[ref].TransitsDeclared() {
+			v.undeclared = append(v.undeclared, undeclaredAction(userID, ref))
+		}
+		for _, target := range actions[ref].TransitTargets() {
+			v.checkTransit(userID, "action "+string(ref), target)
+		}
+	}
+	for _, ref := range sortedRefs(stages) {
+		v.validateStage(userID, stages[ref])
+	}
+}
+
+func undeclaredAction(userID int64, ref model.ResourceRef) string {
+	if userID != 0 {
+		return fmt.Sprintf("action %s (user %d)", ref, userID)
+	}
+	return "action " + string(ref)
+}
+
+func (v *validator) validateStage(userID int64, stg *model.Stage) {
+	entity := "stage " + string(stg.SelfRef())
+	if !stg.DefaultActionRef().Empty() {
+		v.checkAction(userID, entity, stg.DefaultActionRef())
+	} else if stg.CustomInputAllowed() {
+		v.report(MissingDefaultActionIssue, userID, entity, "custom input is allowed without a default action")
+	}
+	for _, route := range stg.InputRoutes() {
+		v.checkAction(userID, entity, route.ActionRef())
+	}
+	if validation := stg.InputValidation(); validation != nil && !validation.EscalationRef.Empty() {
+		v.checkAction(userID, entity, validation.EscalationRef)
+	}
+	for _, cmd := range stg.Commands() {
+		v.checkCommand(userID, entity+" command "+string(cmd.SelfRef()), cmd)
+	}
+	if policy, allowed := stg.CommandPolicy(); policy == model.ListedCommandsAllowed {
+		for _, cmdRef := range allowed {
+			if _, ok := v.registry.lookupCommand(userID, cmdRef); !ok {
+				v.report(DanglingRefIssue, userID, entity, "allowed command %s not found", cmdRef)
+			}
+		}
+	}
+	for i, timer := range stg.Timers() {
+		timerEntity := fmt.Sprintf("%s timer %d", entity, i)
+		if !timer.ActionRef.Empty() {
+			v.checkAction(userID, timerEntity, timer.ActionRef)
+		}
+		if timer.Transit != nil && timer.Transit.Mode != model.TransitPop {
+			v.checkTransit(userID, timerEntity, timer.Transit.TargetRef)
+		}
+		v.validateMessages(userID, timerEntity, timer.Messages)
+	}
+	if initializer, ok := stg.Initializer().(*model.StaticStageInitializer); ok {
+		v.validateMessages(userID, entity+" initializer", initializer.Messages)
+	}
+}
+
+func (v *validator) validateMessages(userID int64, entity string, msgs []*model.Message) {
+	for _, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+		var replyButtons []*model.ReplyButton
+		for _, row := range msg.ReplyKeyboard {
+			replyButtons = append(replyButtons, row...)
+		}
+		texts := make(map[string]bool)
+		for _, button := range replyButtons {
+			v.checkButtonText(userID, entity, texts, button.Text)
+			if !button.ActionRef.Empty() {
+				v.checkAction(userID, entity+" button "+button.Text, button.ActionRef)
+			}
+		}
+
+		var inlineButtons []*model.InlineButton
+		for _, row := range msg.InlineKeyboard {
+			inlineButtons = append(inlineButtons, row...)
+		}
+		v.validateInlineButtons(userID, entity, inlineButtons)
+		if msg.MultiPageKeyboard != nil {
+			v.validateInlineButtons(userID, entity, msg.MultiPageKeyboard.AllButtons)
+		}
+	}
+}
+
+func (v *validator) validateInlineButtons(userID int64, entity string, buttons []*model.InlineButton) {
+	texts := make(map[string]bool)
+	for _, button := range buttons {
+		v.checkButtonText(userID, entity, texts, button.Text)
+		if button.IsCallback() && !button.CallbackHandlerRef.Empty() {
+			if _, ok := v.registry.lookupCallbackHandler(userID, button.CallbackHandlerRef); !ok {
+				v.report(DanglingRefIssue, userID, entity+" button "+button.Text, "callback handler %s not found", button.CallbackHandlerRef)
+			}
+		}
+	}
+}
+
+func (v *validator) checkButtonText(userID int64, entity string, texts map[string]bool, text string) {
+	if texts[text] {
+		v.report(DuplicateButtonIssue, userID, entity, "button %q appears more than once in a keyboard", text)
+	}
+	texts[text] = true
+}
+
+func (v *validator) checkCommand(userID int64, entity string, cmd *model.Command) {
+	v.checkAction(userID, entity, cmd.ActionRef())
+}
+
+func (v *validator) checkAction(userID int64, entity string, actionRef model.ResourceRef) {
+	if _, ok := v.registry.lookupAction(userID, actionRef); !ok {
+		v.report(DanglingRefIssue, userID, entity, "action %s not found", actionRef)
+	}
+}
+
+func (v *validator) checkTransit(userID int64, entity string, stageRef model.ResourceRef) {
+	v.reachable[stageRef] = true
+	if _, ok := v.registry.lookupStage(userID, stageRef); !ok {
+		v.report(DanglingRefIssue, userID, entity, "transit target stage %s not found", stageRef)
+	}
+}
@@ -0,0 +1,80 @@
+package galaxia
+
+import (
+	"log"
+
+	"github.com/atsegelnyk/galaxia/filecache"
+	"github.com/atsegelnyk/galaxia/model"
+	"github.com/atsegelnyk/galaxia/utils"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// resolveCachedFiles returns a copy of msg with uploads replaced by cached file ids,
+// along with the cache keys of the media left to upload, one per media item.
+// Messages of static initializers are shared between users, so msg itself is never modified.
+func (p *Processor) resolveCachedFiles(msg *model.Message) (*model.Message, []string) {
+	if p.fileCache == nil || (msg.Media == nil && msg.MediaGroup == nil) {
+		return msg, nil
+	}
+
+	resolved := *msg
+	if msg.Media != nil {
+		media, key := p.resolveCachedMedia(msg.Media)
+		resolved.Media = media
+		return &resolved, []string{key}
+	}
+
+	keys := make([]string, len(msg.MediaGroup.Media))
+	group := &model.MediaGroup{}
+	for i, item := range msg.MediaGroup.Media {
+		media, key := p.resolveCachedMedia(item)
+		group.Media = append(group.Media, media)
+		keys[i] = key
+	}
+	resolved.MediaGroup = group
+	return &resolved, keys
+}
+
+func (p *Processor) resolveCachedMedia(media *model.Media) (*model.Media, string) {
+	key, err := filecache.Key(media.Type, media.File)
+	if err != nil {
+		log.Println(err)
+		return media, ""
+	}
+	if key == "" {
+		return media, ""
+	}
+
+	fileID, err := p.fileCache.Get(key)
+	if err != nil {
+		return media, key
+	}
+	cached := *media
+	cached.File = model.NewFileID(fileID)
+	return &cached, ""
+}
+
+// cacheSentFiles records file ids of freshly uploaded media, sent holds the messages
+// returned for the media request of msg in the same order as its media items.
+func (p *Processor) cacheSentFiles(msg *model.Message, keys []string, sent []tgbotapi.Message) {
+	var media []*model.Media
+	if msg.Media != nil {
+		media = []*model.Media{msg.Media}
+	} else if msg.MediaGroup != nil {
+		media = msg.MediaGroup.Media
+	}
+
+	for i, key := range keys {
+		if key == "" || i >= len(sent) || i >= len(media) {
+			continue
+		}
+		fileID := utils.SentFileID(sent[i], media[i].Type)
+		if fileID == "" {
+			continue
+		}
+		err := p.fileCache.Set(key, fileID)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
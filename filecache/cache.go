@@ -0,0 +1,47 @@
+package filecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/atsegelnyk/galaxia/model"
+)
+
+var NotFoundError = errors.New("file not found")
+
+// FileCache maps uploaded content to the file_id Telegram assigned to it,
+// so repeated sends of the same bytes reference the file instead of uploading it.
+type FileCache interface {
+	Get(key string) (string, error)
+	Set(key string, fileID string) error
+}
+
+// Key returns the content hash key of an upload. Files referenced by ID or URL
+// and reader sources, which can't be read twice, are not cacheable and get an empty key.
+func Key(mediaType model.MediaType, file *model.InputFile) (string, error) {
+	if file == nil || !file.NeedsUpload() {
+		return "", nil
+	}
+
+	hash := sha256.New()
+	switch {
+	case file.Bytes != nil:
+		hash.Write(file.Bytes)
+	case file.Path != "":
+		f, err := os.Open(file.Path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		_, err = io.Copy(hash, f)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	return string(mediaType) + ":" + hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,31 @@
+package filecache
+
+import "sync"
+
+type InMemoryFileCache struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func NewInMemoryFileCache() *InMemoryFileCache {
+	return &InMemoryFileCache{
+		mu:    sync.Mutex{},
+		files: make(map[string]string),
+	}
+}
+
+func (c *InMemoryFileCache) Get(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fileID, ok := c.files[key]; ok {
+		return fileID, nil
+	}
+	return "", NotFoundError
+}
+
+func (c *InMemoryFileCache) Set(key string, fileID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[key] = fileID
+	return nil
+}
@@ -0,0 +1,94 @@
+package filecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const fileKey = "%s:file"
+
+type RedisFileCache struct {
+	ctx           context.Context
+	keyPrefix     string
+	ttl           time.Duration
+	client        *redis.Client
+	clusterClient *redis.ClusterClient
+}
+
+type RedisFileCacheOption func(*RedisFileCache)
+
+func NewRedisFileCache(ctx context.Context, opts ...RedisFileCacheOption) *RedisFileCache {
+	c := &RedisFileCache{
+		ctx: ctx,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func WithClient(client *redis.Client) RedisFileCacheOption {
+	return func(c *RedisFileCache) {
+		c.client = client
+	}
+}
+
+func WithClusterClient(client *redis.ClusterClient) RedisFileCacheOption {
+	return func(c *RedisFileCache) {
+		c.clusterClient = client
+	}
+}
+
+func WithKeyPrefix(prefix string) RedisFileCacheOption {
+	return func(c *RedisFileCache) {
+		c.keyPrefix = prefix
+	}
+}
+
+// WithTTL limits how long file ids are kept, zero keeps them forever.
+func WithTTL(ttl time.Duration) RedisFileCacheOption {
+	return func(c *RedisFileCache) {
+		c.ttl = ttl
+	}
+}
+
+func (c *RedisFileCache) Get(key string) (string, error) {
+	fileID, err := c.get(c.buildFileKey(key)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", NotFoundError
+		}
+		return "", err
+	}
+	return fileID, nil
+}
+
+func (c *RedisFileCache) Set(key string, fileID string) error {
+	return c.set(c.buildFileKey(key), fileID, c.ttl).Err()
+}
+
+func (c *RedisFileCache) get(key string) *redis.StringCmd {
+	if c.client != nil {
+		return c.client.Get(c.ctx, key)
+	}
+	return c.clusterClient.Get(c.ctx, key)
+}
+
+func (c *RedisFileCache) set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	if c.client != nil {
+		return c.client.Set(c.ctx, key, value, expiration)
+	}
+	return c.clusterClient.Set(c.ctx, key, value, expiration)
+}
+
+func (c *RedisFileCache) buildFileKey(key string) string {
+	keyBase := fmt.Sprintf(fileKey, key)
+	if c.keyPrefix != "" {
+		keyBase = c.keyPrefix + keyBase
+	}
+	return keyBase
+}
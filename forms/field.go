@@ -0,0 +1,234 @@
+package forms
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atsegelnyk/galaxia/model"
+)
+
+const DefaultDateLayout = "2006-01-02"
+
+type FieldType int
+
+const (
+	TextField FieldType = iota
+	IntField
+	FloatField
+	BoolField
+	DateField
+	EmailField
+	PhoneField
+	ChoiceField
+)
+
+var InvalidValueError = errors.New("invalid value")
+
+type Field struct {
+	name       string
+	label      string
+	prompt     string
+	fieldType  FieldType
+	optional   bool
+	choices    []string
+	dateLayout string
+	errorText  string
+	validators []model.InputValidator
+}
+
+type FieldOption func(*Field)
+
+func NewField(name string, fieldType FieldType, prompt string, opts ...FieldOption) *Field {
+	f := &Field{
+		name:       name,
+		label:      name,
+		prompt:     prompt,
+		fieldType:  fieldType,
+		dateLayout: DefaultDateLayout,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Optional lets the user skip the field, skipped fields are left out of the answers.
+func Optional() FieldOption {
+	return func(f *Field) {
+		f.optional = true
+	}
+}
+
+func (f *Field) Optional() *Field {
+	f.optional = true
+	return f
+}
+
+// WithLabel sets the name the field is shown with in the summary and edit menu.
+func WithLabel(label string) FieldOption {
+	return func(f *Field) {
+		f.label = label
+	}
+}
+
+func (f *Field) WithLabel(label string) *Field {
+	f.label = label
+	return f
+}
+
+func WithChoices(choices ...string) FieldOption {
+	return func(f *Field) {
+		f.choices = choices
+	}
+}
+
+func (f *Field) WithChoices(choices ...string) *Field {
+	f.choices = choices
+	return f
+}
+
+func WithDateLayout(layout string) FieldOption {
+	return func(f *Field) {
+		f.dateLayout = layout
+	}
+}
+
+func (f *Field) WithDateLayout(layout string) *Field {
+	f.dateLayout = layout
+	return f
+}
+
+// WithErrorText replaces Texts.InvalidInput shown to the user before the prompt is repeated.
+func WithErrorText(text string) FieldOption {
+	return func(f *Field) {
+		f.errorText = text
+	}
+}
+
+func (f *Field) WithErrorText(text string) *Field {
+	f.errorText = text
+	return f
+}
+
+// WithValidators checks the trimmed input with the model input validators before it
+// is parsed into the field type, their normalized values are discarded.
+func WithValidators(validators ...model.InputValidator) FieldOption {
+	return func(f *Field) {
+		f.validators = append(f.validators, validators...)
+	}
+}
+
+func (f *Field) WithValidators(validators ...model.InputValidator) *Field {
+	f.validators = append(f.validators, validators...)
+	return f
+}
+
+func (f *Field) Name() string {
+	return f.name
+}
+
+// parse converts the user input into the field value and runs the validators.
+func (f *Field) parse(input string, texts Texts) (interface{}, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, fmt.Errorf("%w: empty input", InvalidValueError)
+	}
+	for _, validator := range f.validators {
+		_, err := validator(input)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var value interface{}
+	var err error
+	switch f.fieldType {
+	case IntField:
+		value, err = strconv.ParseInt(input, 10, 64)
+	case FloatField:
+		value, err = strconv.ParseFloat(strings.Replace(input, ",", ".", 1), 64)
+	case BoolField:
+		value, err = parseBool(input, texts)
+	case DateField:
+		value, err = time.Parse(f.dateLayout, input)
+	case EmailField:
+		value, err = model.ParseEmail(input)
+	case PhoneField:
+		value, err = model.ParsePhone(input)
+	case ChoiceField:
+		value, err = f.parseChoice(input)
+	default:
+		value = input
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", InvalidValueError, err)
+	}
+	return value, nil
+}
+
+func (f *Field) parseChoice(input string) (string, error) {
+	for _, choice := range f.choices {
+		if strings.EqualFold(choice, input) {
+			return choice, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not one of the choices", input)
+}
+
+func (f *Field) errorMessage(texts Texts) string {
+	if f.errorText != "" {
+		return f.errorText
+	}
+	if texts.InvalidInput != "" {
+		return texts.InvalidInput
+	}
+	return model.DefaultInputErrorText
+}
+
+// store converts a parsed value into its session representation. Dates are kept as
+// RFC 3339 strings since the session misc only holds JSON compatible values.
+func (f *Field) store(value interface{}) interface{} {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return value
+}
+
+// display formats a stored value for the confirmation summary.
+func (f *Field) display(value interface{}, texts Texts) string {
+	switch v := value.(type) {
+	case float64:
+		if f.fieldType == IntField {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return texts.Yes
+		}
+		return texts.No
+	case string:
+		if f.fieldType == DateField {
+			t, err := time.Parse(time.RFC3339, v)
+			if err == nil {
+				return t.Format(f.dateLayout)
+			}
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func parseBool(input string, texts Texts) (bool, error) {
+	switch {
+	case strings.EqualFold(input, texts.Yes):
+		return true, nil
+	case strings.EqualFold(input, texts.No):
+		return false, nil
+	}
+	return strconv.ParseBool(strings.ToLower(input))
+}
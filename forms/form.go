@@ -0,0 +1,429 @@
+package forms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/atsegelnyk/galaxia/entityregistry"
+	"github.com/atsegelnyk/galaxia/model"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const miscKeyPrefix = "galaxia.forms."
+
+var InvalidFormError = errors.New("invalid form")
+
+// Texts are the captions of the buttons and service messages of a form.
+type Texts struct {
+	Skip         string
+	Yes          string
+	No           string
+	SharePhone   string
+	Confirm      string
+	Edit         string
+	Cancel       string
+	Summary      string
+	EditPrompt   string
+	InvalidInput string
+}
+
+var DefaultTexts = Texts{
+	Skip:         "Skip",
+	Yes:          "Yes",
+	No:           "No",
+	SharePhone:   "Share phone number",
+	Confirm:      "Confirm",
+	Edit:         "Edit",
+	Cancel:       "Cancel",
+	Summary:      "Please check your answers:",
+	EditPrompt:   "Which answer do you want to change?",
+	InvalidInput: model.DefaultInputErrorText,
+}
+
+// CompletionFunc receives the answers once the user has filled in and confirmed the form.
+// It should transit the user away from the form stages.
+type CompletionFunc[T any] func(ctx *model.UserContext, answers T) *model.UserUpdate
+
+type settings struct {
+	fields    []*Field
+	confirm   bool
+	texts     Texts
+	cancelRef model.ResourceRef
+}
+
+type FormOption func(*settings)
+
+func WithFields(fields ...*Field) FormOption {
+	return func(s *settings) {
+		s.fields = append(s.fields, fields...)
+	}
+}
+
+// WithConfirmation toggles the summary with confirm and edit buttons shown after the
+// last field, it is enabled by default.
+func WithConfirmation(confirm bool) FormOption {
+	return func(s *settings) {
+		s.confirm = confirm
+	}
+}
+
+func WithTexts(texts Texts) FormOption {
+	return func(s *settings) {
+		s.texts = texts
+	}
+}
+
+// WithCancel adds a cancel button to every form stage linked to actionRef.
+func WithCancel(actionRef model.ResourceRef) FormOption {
+	return func(s *settings) {
+		s.cancelRef = actionRef
+	}
+}
+
+// Form is an ordered list of fields compiled into a stage per field plus the
+// confirmation and edit stages. Answers are decoded into T with encoding/json,
+// so the struct fields are matched by their json tags against the field names.
+type Form[T any] struct {
+	name       string
+	onComplete CompletionFunc[T]
+	settings
+}
+
+func New[T any](name string, onComplete CompletionFunc[T], opts ...FormOption) *Form[T] {
+	f := &Form[T]{
+		name:       name,
+		onComplete: onComplete,
+		settings: settings{
+			confirm: true,
+			texts:   DefaultTexts,
+		},
+	}
+	for _, opt := range opts {
+		opt(&f.settings)
+	}
+	return f
+}
+
+func (f *Form[T]) WithFields(fields ...*Field) *Form[T] {
+	f.fields = append(f.fields, fields...)
+	return f
+}
+
+// StartActionRef refers to the action resetting the answers and moving the user to
+// the first field. Link commands and buttons starting the form to it.
+func (f *Form[T]) StartActionRef() model.ResourceRef {
+	return model.ResourceRef(f.name + ":start")
+}
+
+func (f *Form[T]) fieldStageRef(field *Field) model.ResourceRef {
+	return model.ResourceRef(f.name + ":field:" + field.name)
+}
+
+func (f *Form[T]) fieldInputRef(field *Field) model.ResourceRef {
+	return model.ResourceRef(f.name + ":field:" + field.name + ":input")
+}
+
+func (f *Form[T]) fieldEditRef(field *Field) model.ResourceRef {
+	return model.ResourceRef(f.name + ":field:" + field.name + ":edit")
+}
+
+func (f *Form[T]) confirmStageRef() model.ResourceRef {
+	return model.ResourceRef(f.name + ":confirm")
+}
+
+func (f *Form[T]) submitActionRef() model.ResourceRef {
+	return model.ResourceRef(f.name + ":submit")
+}
+
+func (f *Form[T]) editStageRef() model.ResourceRef {
+	return model.ResourceRef(f.name + ":edit")
+}
+
+func (f *Form[T]) editActionRef() model.ResourceRef {
+	return model.ResourceRef(f.name + ":edit")
+}
+
+func (f *Form[T]) validate() error {
+	if len(f.fields) == 0 {
+		return fmt.Errorf("%w: form %s has no fields", InvalidFormError, f.name)
+	}
+	names := make(map[string]bool, len(f.fields))
+	for _, field := range f.fields {
+		if names[field.name] {
+			return fmt.Errorf("%w: form %s has duplicate field %s", InvalidFormError, f.name, field.name)
+		}
+		names[field.name] = true
+		if field.fieldType == ChoiceField && len(field.choices) == 0 {
+			return fmt.Errorf("%w: choice field %s has no choices", InvalidFormError, field.name)
+		}
+	}
+	return nil
+}
+
+// Register compiles the form into stages and actions of the registry.
+func (f *Form[T]) Register(er *entityregistry.Registry) error {
+	err := f.validate()
+	if err != nil {
+		return err
+	}
+
+	actions := []*model.Action{
+		model.NewAction(string(f.StartActionRef()), f.start).WithTransitTargets(f.fieldStageRef(f.fields[0])),
+	}
+	var stages []*model.Stage
+	for i, field := range f.fields {
+		stage, err := f.fieldStage(field)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, stage)
+		input := model.NewAction(string(f.fieldInputRef(field)), f.input(i))
+		if i+1 < len(f.fields) {
+			input.WithTransitTargets(f.fieldStageRef(f.fields[i+1]))
+		}
+		if f.confirm {
+			input.WithTransitTargets(f.confirmStageRef())
+		}
+		actions = append(actions,
+			input,
+			model.NewAction(string(f.fieldEditRef(field)), f.editField(field)).WithTransitTargets(f.fieldStageRef(field)),
+		)
+	}
+	if f.confirm {
+		editStage, err := f.editStage()
+		if err != nil {
+			return err
+		}
+		stages = append(stages, f.confirmStage(), editStage)
+		actions = append(actions,
+			model.NewAction(string(f.submitActionRef()), f.submit),
+			model.NewAction(string(f.editActionRef()), f.edit).WithTransitTargets(f.editStageRef()),
+		)
+	}
+
+	for _, action := range actions {
+		err = er.RegisterAction(action)
+		if err != nil {
+			return err
+		}
+	}
+	for _, stage := range stages {
+		err = er.RegisterStage(stage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Form[T]) fieldStage(field *Field) (*model.Stage, error) {
+	inputRef := f.fieldInputRef(field)
+	var buttons []*model.ReplyButton
+	switch field.fieldType {
+	case ChoiceField:
+		for _, choice := range field.choices {
+			buttons = append(buttons, model.NewReplyButton(choice).LinkAction(inputRef))
+		}
+	case BoolField:
+		buttons = append(buttons,
+			model.NewReplyButton(f.texts.Yes).LinkAction(inputRef),
+			model.NewReplyButton(f.texts.No).LinkAction(inputRef),
+		)
+	case PhoneField:
+		buttons = append(buttons, model.NewContactButton(f.texts.SharePhone).LinkAction(inputRef))
+	}
+	if field.optional {
+		buttons = append(buttons, model.NewReplyButton(f.texts.Skip).LinkAction(inputRef))
+	}
+
+	prompt, err := f.promptMessage(field.prompt, buttons)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewStage(
+		string(f.fieldStageRef(field)),
+		model.WithCustomInputAllowed(true),
+		model.WithDefaultAction(inputRef),
+		model.WithInitializer(model.NewStaticStageInitializer(prompt)),
+	), nil
+}
+
+// promptMessage builds a stage message with the buttons and the cancel button, the
+// keyboard of the previous stage is removed when there are none.
+func (f *Form[T]) promptMessage(text string, buttons []*model.ReplyButton) (*model.Message, error) {
+	if !f.cancelRef.Empty() {
+		buttons = append(buttons, model.NewReplyButton(f.texts.Cancel).LinkAction(f.cancelRef))
+	}
+	message := model.NewMessage(model.WithText(text))
+	if len(buttons) == 0 {
+		return message.WithRemoveKeyboard(false), nil
+	}
+	keyboard, err := model.NewReplyKeyboard(model.NewGrid(model.OnePerRow), buttons...)
+	if err != nil {
+		return nil, err
+	}
+	return message.WithReplyKeyboard(keyboard), nil
+}
+
+func (f *Form[T]) confirmStage() *model.Stage {
+	initializer := model.FuncStageInitializer(func(_ context.Context, ctx *model.UserContext, _ model.ResourceRef) ([]*model.Message, error) {
+		return []*model.Message{f.summaryMessage(ctx)}, nil
+	})
+	return model.NewStage(
+		string(f.confirmStageRef()),
+		model.WithInitializer(initializer),
+	)
+}
+
+func (f *Form[T]) summaryMessage(ctx *model.UserContext) *model.Message {
+	answers := f.state(ctx).answers()
+	var text strings.Builder
+	text.WriteString(f.texts.Summary)
+	for _, field := range f.fields {
+		value, ok := answers[field.name]
+		if !ok {
+			continue
+		}
+		text.WriteString("\n" + field.label + ": " + field.display(value, f.texts))
+	}
+
+	buttons := []*model.ReplyButton{
+		model.NewReplyButton(f.texts.Confirm).LinkAction(f.submitActionRef()),
+		model.NewReplyButton(f.texts.Edit).LinkAction(f.editActionRef()),
+	}
+	// the keyboard has at most three buttons, arranging can't fail
+	message, _ := f.promptMessage(text.String(), buttons)
+	return message
+}
+
+func (f *Form[T]) editStage() (*model.Stage, error) {
+	var buttons []*model.ReplyButton
+	for _, field := range f.fields {
+		buttons = append(buttons, model.NewReplyButton(field.label).LinkAction(f.fieldEditRef(field)))
+	}
+	prompt, err := f.promptMessage(f.texts.EditPrompt, buttons)
+	if err != nil {
+		return nil, err
+	}
+	return model.NewStage(
+		string(f.editStageRef()),
+		model.WithInitializer(model.NewStaticStageInitializer(prompt)),
+	), nil
+}
+
+func (f *Form[T]) start(ctx *model.UserContext, _ *tgbotapi.Update) *model.UserUpdate {
+	f.resetState(ctx)
+	return model.NewUserUpdate(ctx.UserID, model.WithTransit(f.fieldStageRef(f.fields[0]), false))
+}
+
+func (f *Form[T]) input(i int) model.UserActionFunc {
+	field := f.fields[i]
+	return func(ctx *model.UserContext, update *tgbotapi.Update) *model.UserUpdate {
+		state := f.state(ctx)
+		input := model.MessageInput(update.Message)
+		switch {
+		case field.optional && input == f.texts.Skip:
+			delete(state.answers(), field.name)
+		case field.fieldType == PhoneField && update.Message != nil && update.Message.Contact != nil:
+			state.answers()[field.name] = "+" + strings.TrimPrefix(update.Message.Contact.PhoneNumber, "+")
+		default:
+			value, err := field.parse(input, f.texts)
+			if err != nil {
+				log.Printf("form %s rejected %s of user %d: %v", f.name, field.name, ctx.UserID, err)
+				return model.NewUserUpdate(ctx.UserID, model.WithMessages(
+					model.NewMessage(model.WithText(field.errorMessage(f.texts))),
+				))
+			}
+			state.answers()[field.name] = field.store(value)
+		}
+
+		if state.editing() {
+			state.setEditing(false)
+			return model.NewUserUpdate(ctx.UserID, model.WithTransitReplace(f.confirmStageRef(), false))
+		}
+		if i+1 < len(f.fields) {
+			return model.NewUserUpdate(ctx.UserID, model.WithTransitReplace(f.fieldStageRef(f.fields[i+1]), false))
+		}
+		if f.confirm {
+			return model.NewUserUpdate(ctx.UserID, model.WithTransitReplace(f.confirmStageRef(), false))
+		}
+		return f.complete(ctx)
+	}
+}
+
+func (f *Form[T]) edit(ctx *model.UserContext, _ *tgbotapi.Update) *model.UserUpdate {
+	return model.NewUserUpdate(ctx.UserID, model.WithTransitReplace(f.editStageRef(), false))
+}
+
+func (f *Form[T]) editField(field *Field) model.UserActionFunc {
+	return func(ctx *model.UserContext, _ *tgbotapi.Update) *model.UserUpdate {
+		f.state(ctx).setEditing(true)
+		return model.NewUserUpdate(ctx.UserID, model.WithTransitReplace(f.fieldStageRef(field), false))
+	}
+}
+
+func (f *Form[T]) submit(ctx *model.UserContext, _ *tgbotapi.Update) *model.UserUpdate {
+	return f.complete(ctx)
+}
+
+func (f *Form[T]) complete(ctx *model.UserContext) *model.UserUpdate {
+	var answers T
+	data, err := json.Marshal(f.state(ctx).answers())
+	if err == nil {
+		err = json.Unmarshal(data, &answers)
+	}
+	if err != nil {
+		return model.NewUserUpdate(ctx.UserID, model.WithMessages(
+			model.NewMessage(model.WithText(err.Error())),
+		))
+	}
+	delete(ctx.Misc, f.miscKey())
+	return f.onComplete(ctx, answers)
+}
+
+func (f *Form[T]) miscKey() string {
+	return miscKeyPrefix + f.name
+}
+
+// formState is the progress of a user through the form, kept in the user context misc
+// so it is persisted together with the session.
+type formState map[string]interface{}
+
+func (f *Form[T]) resetState(ctx *model.UserContext) {
+	if ctx.Misc == nil {
+		ctx.Misc = make(map[string]interface{})
+	}
+	ctx.Misc[f.miscKey()] = map[string]interface{}{
+		"answers": map[string]interface{}{},
+	}
+}
+
+func (f *Form[T]) state(ctx *model.UserContext) formState {
+	if state, ok := ctx.Misc[f.miscKey()].(map[string]interface{}); ok {
+		return state
+	}
+	f.resetState(ctx)
+	return ctx.Misc[f.miscKey()].(map[string]interface{})
+}
+
+func (s formState) answers() map[string]interface{} {
+	if answers, ok := s["answers"].(map[string]interface{}); ok {
+		return answers
+	}
+	answers := make(map[string]interface{})
+	s["answers"] = answers
+	return answers
+}
+
+func (s formState) editing() bool {
+	editing, _ := s["editing"].(bool)
+	return editing
+}
+
+func (s formState) setEditing(editing bool) {
+	s["editing"] = editing
+}
@@ -3,19 +3,26 @@ package galaxia
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/atsegelnyk/galaxia/auth"
+	"github.com/atsegelnyk/galaxia/filecache"
 	"github.com/atsegelnyk/galaxia/metrics"
 	"time"
 
 	"github.com/atsegelnyk/galaxia/entityregistry"
 	"github.com/atsegelnyk/galaxia/model"
 	"github.com/atsegelnyk/galaxia/session"
+	"github.com/atsegelnyk/galaxia/timers"
 	"github.com/atsegelnyk/galaxia/utils"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"log"
 )
 
-const StartCMDName = "start"
+const (
+	StartCMDName = "start"
+
+	DefaultCommandNotAllowedText = "This command is not available here."
+)
 
 type ProcessorOption func(*Processor)
 
@@ -26,13 +33,21 @@ type Processor struct {
 	entityRegistry    *entityregistry.Registry
 	exporter          *metrics.PrometheusExporter
 	auther            auth.Auther
+	fileCache         filecache.FileCache
+	timerStore        timers.Store
+	commandMenuSync   bool
+	foldCommandCase   bool
+	strictValidation  bool
+
+	commandNotAllowedText string
 }
 
 func NewProcessor(opts ...ProcessorOption) *Processor {
 	g := &Processor{
-		entityRegistry: entityregistry.New(),
-		auther:         auth.NewFakeAlwaysAuther(),
-		exporter:       metrics.NewPrometheusExporter(),
+		entityRegistry:        entityregistry.New(),
+		auther:                auth.NewFakeAlwaysAuther(),
+		exporter:              metrics.NewPrometheusExporter(),
+		commandNotAllowedText: DefaultCommandNotAllowedText,
 	}
 	for _, opt := range opts {
 		opt(g)
@@ -74,6 +89,50 @@ func WithAuther(a auth.Auther) ProcessorOption {
 	}
 }
 
+func WithFileCache(c filecache.FileCache) ProcessorOption {
+	return func(g *Processor) {
+		g.fileCache = c
+	}
+}
+
+// WithTimerStore enables stage timers, without a store they are ignored.
+func WithTimerStore(s timers.Store) ProcessorOption {
+	return func(g *Processor) {
+		g.timerStore = s
+	}
+}
+
+// WithCommandMenuSync publishes the registry commands as the Telegram command menus on
+// start and keeps chat menus in line with the stage and command overrides of the user.
+func WithCommandMenuSync(enabled bool) ProcessorOption {
+	return func(g *Processor) {
+		g.commandMenuSync = enabled
+	}
+}
+
+// WithCaseInsensitiveCommands matches commands and their aliases ignoring case.
+func WithCaseInsensitiveCommands(enabled bool) ProcessorOption {
+	return func(g *Processor) {
+		g.foldCommandCase = enabled
+	}
+}
+
+// WithStrictValidation refuses to start with registry validation issues, which are
+// only logged otherwise.
+func WithStrictValidation(strict bool) ProcessorOption {
+	return func(g *Processor) {
+		g.strictValidation = strict
+	}
+}
+
+// WithCommandNotAllowedText replaces the reply to commands the command policy of the
+// current stage blocks, an empty text leaves them unanswered.
+func WithCommandNotAllowedText(text string) ProcessorOption {
+	return func(g *Processor) {
+		g.commandNotAllowedText = text
+	}
+}
+
 func WithMetricAddr(addr string) ProcessorOption {
 	return func(g *Processor) {
 		g.exporter.Listen = addr
@@ -86,7 +145,18 @@ func (p *Processor) Start(ctx context.Context) {
 		log.Fatal(err)
 	}
 
+	if p.commandMenuSync {
+		p.entityRegistry.OnCommandsChanged(p.commandsChanged)
+		err = p.publishCommands()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
 	go p.exporter.Serve(ctx)
+	if p.timerStore != nil {
+		go p.runTimers(ctx)
+	}
 	log.Println("start pooling")
 
 	u := tgbotapi.NewUpdate(0)
@@ -102,7 +172,7 @@ func (p *Processor) Start(ctx context.Context) {
 			return
 		case update := <-updates:
 			go func() {
-				err = p.handleUpdate(&update)
+				err = p.handleUpdate(ctx, &update)
 				if err != nil {
 					log.Println(err)
 				}
@@ -113,27 +183,46 @@ func (p *Processor) Start(ctx context.Context) {
 }
 
 func (p *Processor) AsyncUpdate(update *model.UserUpdate) error {
-	ses, err := p.sessionRepository.Get(update.UserID)
+	return p.asyncUpdate(context.Background(), update.UserID, func(*session.Session) (*model.UserUpdate, error) {
+		return update, nil
+	})
+}
+
+// asyncUpdate processes the update built for the session of the user, a nil update
+// leaves the session as is.
+func (p *Processor) asyncUpdate(ctx context.Context, userID int64, build func(ses *session.Session) (*model.UserUpdate, error)) error {
+	ses, err := p.sessionRepository.Get(userID)
 	if err != nil {
 		if !errors.Is(err, session.NotFoundError) {
 			return err
 		}
-		ses = session.NewSession(update.UserID)
+		ses = session.NewSession(userID)
+	}
+	update, err := build(ses)
+	if err != nil || update == nil {
+		return err
 	}
-	return p.processUserUpdate(ses, update)
+	return p.processDetachedUpdate(ctx, ses, update)
 }
 
 func (p *Processor) preflightCheck() error {
+	if p.entityRegistry == nil {
+		return errors.New("entity registry is nil")
+	}
 	_, err := p.entityRegistry.GetCommand(0, StartCMDName)
 	if err != nil {
 		return err
 	}
-	if p.entityRegistry == nil {
-		return errors.New("entity registry is nil")
-	}
 	if p.sessionRepository == nil {
 		return errors.New("session repository is nil")
 	}
+	err = p.entityRegistry.Validate()
+	if err != nil {
+		if p.strictValidation {
+			return err
+		}
+		log.Println(err)
+	}
 	return nil
 }
 
@@ -156,7 +245,7 @@ func (p *Processor) enrichSession(ses *session.Session, update *tgbotapi.Update)
 	ses.UserContext.LastName = update.Message.From.LastName
 }
 
-func (p *Processor) handleUpdate(update *tgbotapi.Update) error {
+func (p *Processor) handleUpdate(ctx context.Context, update *tgbotapi.Update) error {
 	if update.Message != nil {
 		err := p.auther.AuthN(update.Message.Chat.ID)
 		if err != nil {
@@ -167,6 +256,11 @@ func (p *Processor) handleUpdate(update *tgbotapi.Update) error {
 			return err
 		}
 
+		call := parseCommand(update.Message)
+		if call != nil && !p.addressedToBot(update.Message, call) {
+			return nil
+		}
+
 		p.exporter.Increase(metrics.UserMessagesSentCountMetric)
 		ses, err := p.sessionRepository.Get(update.Message.Chat.ID)
 		if err != nil {
@@ -178,11 +272,12 @@ func (p *Processor) handleUpdate(update *tgbotapi.Update) error {
 			p.enrichSession(ses, update)
 		}
 
+		defer p.armTimers(ses)
 		ses.AppendStageMessages(update.Message.MessageID)
-		if update.Message.Command() != "" {
-			return p.handleCMD(ses, update)
+		if p.isCommand(ses, call) {
+			return p.handleCMD(ctx, ses, update, call)
 		}
-		return p.handleMessage(ses, update)
+		return p.handleMessage(ctx, ses, update)
 	}
 
 	if update.CallbackQuery != nil {
@@ -190,18 +285,35 @@ func (p *Processor) handleUpdate(update *tgbotapi.Update) error {
 		if err != nil {
 			return err
 		}
-		return p.handleCallbackQuery(ses, update)
+		defer p.armTimers(ses)
+		return p.handleCallbackQuery(ctx, ses, update)
 	}
 	return nil
 }
 
 // event processors by type
 
-func (p *Processor) handleCMD(session *session.Session, update *tgbotapi.Update) error {
-	cmd, err := p.entityRegistry.GetCommand(update.Message.Chat.ID, model.ResourceRef(update.Message.Command()))
+func (p *Processor) handleCMD(ctx context.Context, session *session.Session, update *tgbotapi.Update, call *commandCall) error {
+	cmd, err := p.resolveCommand(session, call.name)
+	if errors.Is(err, model.CommandNotAllowedError) && p.commandNotAllowedText != "" {
+		return p.processUserUpdate(ctx, session, model.NewUserUpdate(
+			session.UserID,
+			model.WithMessages(model.NewMessage(model.WithText(p.commandNotAllowedText))),
+			model.WithoutReinit(),
+		))
+	}
 	if err != nil {
 		return err
 	}
+	args, err := cmd.ParseArgs(call.args)
+	if err != nil {
+		return p.processUserUpdate(ctx, session, model.NewUserUpdate(
+			session.UserID,
+			model.WithMessages(model.NewMessage(model.WithText(cmd.Usage()))),
+			model.WithoutReinit(),
+		))
+	}
+	session.UserContext.CommandArgs = args
 	action, err := p.entityRegistry.GetAction(
 		update.Message.Chat.ID,
 		cmd.ActionRef(),
@@ -218,10 +330,10 @@ func (p *Processor) handleCMD(session *session.Session, update *tgbotapi.Update)
 	p.exporter.ObserveWithLabels(metrics.RequestDurationBucketMetric, time.Since(start), map[string]string{
 		metrics.ActionRefLabel: string(action.SelfRef()),
 	})
-	return p.processUserUpdate(session, userUpdate)
+	return p.processUserUpdate(ctx, session, userUpdate)
 }
 
-func (p *Processor) handleMessage(session *session.Session, update *tgbotapi.Update) error {
+func (p *Processor) handleMessage(ctx context.Context, session *session.Session, update *tgbotapi.Update) error {
 	stageRef := session.GetCurrentStage()
 	if stageRef.Empty() {
 		startCmd, _ := p.entityRegistry.GetCommand(update.Message.Chat.ID, StartCMDName)
@@ -240,7 +352,7 @@ func (p *Processor) handleMessage(session *session.Session, update *tgbotapi.Upd
 		p.exporter.ObserveWithLabels(metrics.RequestDurationBucketMetric, time.Since(start), map[string]string{
 			metrics.ActionRefLabel: string(action.SelfRef()),
 		})
-		return p.processUserUpdate(session, userUpdate)
+		return p.processUserUpdate(ctx, session, userUpdate)
 	}
 
 	stg, err := p.entityRegistry.GetStage(update.Message.Chat.ID, stageRef)
@@ -253,52 +365,80 @@ func (p *Processor) handleMessage(session *session.Session, update *tgbotapi.Upd
 		return err
 	}
 
-	return p.processUserUpdate(session, userUpdate)
+	return p.processUserUpdate(ctx, session, userUpdate)
 }
 
 func (p *Processor) handleStage(ses *session.Session, stg *model.Stage, update *tgbotapi.Update) (*model.UserUpdate, error) {
-	if actionRef, ok := ses.PendingInputs[update.Message.Text]; ok {
-		action, err := p.entityRegistry.GetAction(update.Message.Chat.ID, actionRef)
-		if err != nil {
-			return nil, err
+	if actionRef, ok := ses.PendingInputs[model.MessageInputKey(update.Message)]; ok {
+		return p.executeStageAction(ses, stg, actionRef, update)
+	}
+
+	for _, route := range stg.InputRoutes() {
+		if route.Match(ses.UserContext, update) {
+			return p.executeStageAction(ses, stg, route.ActionRef(), update)
 		}
-		p.exporter.IncreaseWithLabels(metrics.StageActionProcessedCountMetric, map[string]string{
-			metrics.StageRefLabel:  string(stg.SelfRef()),
-			metrics.ActionRefLabel: string(actionRef),
-		})
-		start := time.Now()
-		userUpdate := action.Func()(ses.UserContext, update)
-		p.exporter.ObserveWithLabels(metrics.RequestDurationBucketMetric, time.Since(start), map[string]string{
-			metrics.ActionRefLabel: string(action.SelfRef()),
-		})
-		return userUpdate, nil
 	}
 
 	if stg.CustomInputAllowed() {
-		defActionRef := stg.DefaultActionRef()
-		defAction, err := p.entityRegistry.GetAction(update.Message.Chat.ID, defActionRef)
-		if err != nil {
-			return nil, err
+		if validation := stg.InputValidation(); validation != nil {
+			return p.handleValidatedInput(ses, stg, validation, update)
 		}
-		p.exporter.IncreaseWithLabels(metrics.StageActionProcessedCountMetric, map[string]string{
-			metrics.StageRefLabel:  string(stg.SelfRef()),
-			metrics.ActionRefLabel: string(defActionRef),
-		})
-		start := time.Now()
-		userUpdate := defAction.Func()(ses.UserContext, update)
-		p.exporter.ObserveWithLabels(metrics.RequestDurationBucketMetric, time.Since(start), map[string]string{
-			metrics.ActionRefLabel: string(defActionRef),
-		})
-		return userUpdate, nil
+		return p.executeStageAction(ses, stg, stg.DefaultActionRef(), update)
 	}
 	return nil, model.UnrecognizedInputError
 }
 
-func (p *Processor) handleCallbackQuery(ses *session.Session, update *tgbotapi.Update) error {
+// handleValidatedInput answers rejected custom input with the validation error text, the
+// stage re-initialization then repeats the prompt. Validator errors are only logged.
+func (p *Processor) handleValidatedInput(ses *session.Session, stg *model.Stage, validation *model.InputValidation, update *tgbotapi.Update) (*model.UserUpdate, error) {
+	value, err := validation.Validate(model.MessageInput(update.Message))
+	if err != nil {
+		log.Printf("stage %s rejected input of user %d: %v", stg.SelfRef(), ses.UserID, err)
+		ses.InputFailures++
+		if validation.Escalates(ses.InputFailures) {
+			ses.InputFailures = 0
+			return p.executeStageAction(ses, stg, validation.EscalationRef, update)
+		}
+		return model.NewUserUpdate(update.Message.Chat.ID, model.WithMessages(
+			model.NewMessage(model.WithText(validation.ErrorMessage())),
+		)), nil
+	}
+
+	ses.InputFailures = 0
+	if validation.MiscKey != "" {
+		if ses.UserContext.Misc == nil {
+			ses.UserContext.Misc = make(map[string]interface{})
+		}
+		ses.UserContext.Misc[validation.MiscKey] = value
+	}
+	return p.executeStageAction(ses, stg, stg.DefaultActionRef(), update)
+}
+
+func (p *Processor) executeStageAction(ses *session.Session, stg *model.Stage, actionRef model.ResourceRef, update *tgbotapi.Update) (*model.UserUpdate, error) {
+	action, err := p.entityRegistry.GetAction(update.Message.Chat.ID, actionRef)
+	if err != nil {
+		return nil, err
+	}
+	p.exporter.IncreaseWithLabels(metrics.StageActionProcessedCountMetric, map[string]string{
+		metrics.StageRefLabel:  string(stg.SelfRef()),
+		metrics.ActionRefLabel: string(actionRef),
+	})
+	start := time.Now()
+	userUpdate := action.Func()(ses.UserContext, update)
+	p.exporter.ObserveWithLabels(metrics.RequestDurationBucketMetric, time.Since(start), map[string]string{
+		metrics.ActionRefLabel: string(action.SelfRef()),
+	})
+	return userUpdate, nil
+}
+
+func (p *Processor) handleCallbackQuery(ctx context.Context, ses *session.Session, update *tgbotapi.Update) error {
 	pendingCallbak, err := ses.GetPendingCallback(update.CallbackQuery.Data)
 	if err != nil {
 		return err
 	}
+	if pendingCallbak.Page != nil {
+		return p.handlePageNavigation(ctx, ses, pendingCallbak.Page, update)
+	}
 
 	callbackHandler, err := p.entityRegistry.GetCallbackHandler(int64(update.CallbackQuery.From.ID), pendingCallbak.HandlerRef)
 	if err != nil {
@@ -313,6 +453,9 @@ func (p *Processor) handleCallbackQuery(ses *session.Session, update *tgbotapi.U
 	}
 
 	ses.UserContext.CallbackData = &pendingCallbak.UserData
+	if update.CallbackQuery.Message != nil {
+		ses.UserContext.CallbackMessageID = update.CallbackQuery.Message.MessageID
+	}
 	p.exporter.IncreaseWithLabels(metrics.CallbacksProcessedCountMetric, map[string]string{
 		metrics.CallbackHandlerRefLabel: string(callbackHandler.SelfRef()),
 	})
@@ -321,62 +464,329 @@ func (p *Processor) handleCallbackQuery(ses *session.Session, update *tgbotapi.U
 	p.exporter.ObserveWithLabels(metrics.RequestDurationBucketMetric, time.Since(start), map[string]string{
 		metrics.ActionRefLabel: string(action.SelfRef()),
 	})
-	return p.processUserUpdate(ses, userUpdate)
+	return p.processUserUpdate(ctx, ses, userUpdate)
+}
+
+// handlePageNavigation flips a multi page keyboard by editing the keyboard of the callback message.
+func (p *Processor) handlePageNavigation(ctx context.Context, ses *session.Session, page *model.MultiPageInlineKeyboard, update *tgbotapi.Update) error {
+	if update.CallbackQuery.Message != nil {
+		ses.UserContext.CallbackMessageID = update.CallbackQuery.Message.MessageID
+	}
+	userUpdate := model.NewUserUpdate(
+		int64(update.CallbackQuery.From.ID),
+		model.WithEditMultiPageKeyboard(0, page),
+		model.WithCallbackQueryResponse(&model.CallbackQueryResponse{
+			CallbackQueryID: update.CallbackQuery.ID,
+		}),
+	)
+	return p.processUserUpdate(ctx, ses, userUpdate)
 }
 
 // user response handlerx
 
-func (p *Processor) processUserUpdate(ses *session.Session, update *model.UserUpdate) error {
-	stageReInit := false
-	if update.Messages != nil {
-		p.callbackMapper(ses, update)
-		stageReInit = true
-	}
+func (p *Processor) processUserUpdate(ctx context.Context, ses *session.Session, update *model.UserUpdate) error {
+	stageReInit := update.Messages != nil && !update.NoReinit
 
-	err := p.processTransit(stageReInit, ses, update)
+	var frame *model.FlowFrame
+	var err error
+	if update.Return != nil {
+		if update.Transit != nil || update.Call != nil || stageReInit {
+			return errors.New("return can't be combined with a transit, a call or a stage reinit")
+		}
+		frame, err = p.processReturn(ses, update)
+	} else {
+		err = p.processCall(ses, update)
+		if err == nil {
+			err = p.processTransit(ctx, stageReInit, ses, update)
+			if err != nil && update.Call != nil {
+				// the user stays in the caller stage when the sub-flow can't be entered
+				ses.PopFlow()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	for i, msg := range update.Messages {
+		msg, err = msg.Normalized()
+		if err != nil {
+			return err
+		}
+		err = msg.Validate()
+		if err != nil {
+			return err
+		}
+		update.Messages[i] = msg
+	}
+	for _, edit := range update.Edits {
+		if edit.Message != nil {
+			edit.Message, err = edit.Message.Normalized()
+			if err != nil {
+				return err
+			}
+		}
+		err = edit.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	err = p.callbackMapper(ses, update)
 	if err != nil {
 		return err
 	}
-	sentMessages, err := p.respond(update)
+	sentMessages, err := p.respond(ses, update)
 	ses.AppendStageMessages(sentMessages...)
 	if err != nil {
 		return err
 	}
 	ses.UserContext.CallbackData = nil
-	return p.sessionRepository.Save(ses)
+	ses.UserContext.CallbackMessageID = 0
+	ses.UserContext.CommandArgs = nil
+	p.syncChatCommands(ses)
+	err = p.sessionRepository.Save(ses)
+	if err != nil || frame == nil {
+		return err
+	}
+	return p.continueFlow(ctx, ses, frame, update.Return.Result)
+}
+
+// processCall turns a sub-flow call into a transit to its entry stage.
+func (p *Processor) processCall(ses *session.Session, update *model.UserUpdate) error {
+	if update.Call == nil {
+		return nil
+	}
+	err := ses.PushFlow(update.Call.ContinuationRef, update.Call.Params)
+	if err != nil {
+		return err
+	}
+	update.Transit = &model.Transit{
+		TargetRef: update.Call.EntryRef,
+		Clean:     update.Call.Clean,
+		Mode:      model.TransitReplace,
+	}
+	return nil
+}
+
+// processReturn leaves the current sub-flow. The caller stage is restored as it
+// was, its continuation action decides what happens next.
+func (p *Processor) processReturn(ses *session.Session, update *model.UserUpdate) (*model.FlowFrame, error) {
+	currentStageRef := ses.GetCurrentStage()
+	if !currentStageRef.Empty() {
+		current, err := p.entityRegistry.GetStage(update.UserID, currentStageRef)
+		if err != nil {
+			return nil, err
+		}
+		exitMessages, err := current.Exit(ses.UserContext)
+		if err != nil {
+			return nil, err
+		}
+		update.Messages = append(update.Messages, exitMessages...)
+	}
+
+	frame, err := ses.PopFlow()
+	if err != nil {
+		return nil, err
+	}
+	if update.Return.Clean {
+		update.ToDeleteMessages = append(update.ToDeleteMessages, ses.StageMessages...)
+		ses.Clean()
+	}
+	return frame, nil
+}
+
+func (p *Processor) continueFlow(ctx context.Context, ses *session.Session, frame *model.FlowFrame, result map[string]interface{}) error {
+	action, err := p.entityRegistry.GetAction(ses.UserID, frame.ContinuationRef)
+	if err != nil {
+		return err
+	}
+	ses.UserContext.FlowResult = result
+	userUpdate, err := p.runDetachedAction(ses, action)
+	ses.UserContext.FlowResult = nil
+	if err != nil || userUpdate == nil {
+		return err
+	}
+	return p.processUserUpdate(ctx, ses, userUpdate)
+}
+
+// runDetachedAction runs an action outside of a Telegram update. Actions commonly read
+// update.Message, so they get a synthetic message from the user, and a panic of the
+// action is turned into an error since there is no update of its own to fail.
+func (p *Processor) runDetachedAction(ses *session.Session, action *model.Action) (userUpdate *model.UserUpdate, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("detached action %s panicked: %v", action.SelfRef(), r)
+		}
+	}()
+	start := time.Now()
+	userUpdate = action.Func()(ses.UserContext, detachedUpdate(ses))
+	p.exporter.ObserveWithLabels(metrics.RequestDurationBucketMetric, time.Since(start), map[string]string{
+		metrics.ActionRefLabel: string(action.SelfRef()),
+	})
+	return userUpdate, nil
+}
+
+func detachedUpdate(ses *session.Session) *tgbotapi.Update {
+	user := &tgbotapi.User{
+		ID:           int(ses.UserID),
+		UserName:     ses.UserContext.Username,
+		FirstName:    ses.UserContext.Name,
+		LastName:     ses.UserContext.LastName,
+		LanguageCode: ses.UserContext.Lang,
+	}
+	chat := &tgbotapi.Chat{ID: ses.UserID, Type: "group"}
+	if ses.UserID > 0 {
+		chat.Type = "private"
+	}
+	return &tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			From: user,
+			Chat: chat,
+			Date: int(time.Now().Unix()),
+		},
+	}
 }
 
 // callbackID mapper
 
-func (p *Processor) callbackMapper(ses *session.Session, update *model.UserUpdate) {
-	for _, message := range update.Messages {
-		if message.InlineKeyboard != nil {
-			for _, row := range message.InlineKeyboard {
-				for _, button := range row {
-					callbackID := utils.GenerateCallbackID()
-					button.Data = callbackID
-					ses.RegisterCallback(callbackID,
-						button.CallbackBehaviour,
-						button.UserData,
-						button.CallbackHandlerRef,
-					)
-				}
+// callbackMapper registers callbacks for every inline button of the update. Buttons are
+// copied before the callback id is assigned since initializer messages are shared between users.
+// Edits replace the keyboard of their target message, so its previous callbacks are dropped.
+func (p *Processor) callbackMapper(ses *session.Session, update *model.UserUpdate) error {
+	for i, message := range update.Messages {
+		update.Messages[i] = p.mapMessageKeyboard(ses, message, 0)
+	}
+
+	for _, edit := range update.Edits {
+		if edit.MessageID == 0 {
+			edit.MessageID = ses.UserContext.CallbackMessageID
+		}
+		if edit.MessageID == 0 {
+			return errors.New("edit target message is unknown")
+		}
+		ses.DropMessageCallbacks(edit.MessageID)
+		if edit.Message != nil {
+			edit.Message = p.mapMessageKeyboard(ses, edit.Message, edit.MessageID)
+		}
+	}
+	return nil
+}
+
+func (p *Processor) mapMessageKeyboard(ses *session.Session, message *model.Message, messageID int) *model.Message {
+	if message.MultiPageKeyboard != nil {
+		mapped := *message
+		mapped.InlineKeyboard = p.mapMultiPageKeyboard(ses, message.MultiPageKeyboard, messageID)
+		mapped.MultiPageKeyboard = nil
+		return &mapped
+	}
+	if message.InlineKeyboard != nil {
+		mapped := *message
+		mapped.InlineKeyboard = p.mapInlineKeyboard(ses, message.InlineKeyboard, messageID)
+		return &mapped
+	}
+	return message
+}
+
+// mapMultiPageKeyboard renders the current page followed by a navigation row. Every
+// navigation callback carries the whole keyboard positioned at its target page.
+func (p *Processor) mapMultiPageKeyboard(ses *session.Session, keyboard *model.MultiPageInlineKeyboard, messageID int) [][]*model.InlineButton {
+	page := keyboard.Page(keyboard.Offset)
+	mapped := p.mapInlineKeyboard(ses, page.CurrentButtons, messageID)
+
+	var navigation []*model.InlineButton
+	if page.HasPrevious() {
+		navigation = append(navigation, p.mapPageButton(ses, page.PreviousButtonText, keyboard.Page(page.PreviousOffset()), messageID))
+	}
+	if page.HasNext() {
+		navigation = append(navigation, p.mapPageButton(ses, page.NextButtonText, keyboard.Page(page.NextOffset()), messageID))
+	}
+	if navigation != nil {
+		mapped = append(mapped, navigation)
+	}
+	return mapped
+}
+
+func (p *Processor) mapPageButton(ses *session.Session, text string, target *model.MultiPageInlineKeyboard, messageID int) *model.InlineButton {
+	callbackID := utils.GenerateCallbackID()
+	target.CurrentButtons = nil
+	ses.RegisterPageCallback(callbackID, target)
+	ses.BindCallbacks(messageID, callbackID)
+	return &model.InlineButton{
+		Text: text,
+		Data: callbackID,
+	}
+}
+
+func (p *Processor) mapInlineKeyboard(ses *session.Session, keyboard [][]*model.InlineButton, messageID int) [][]*model.InlineButton {
+	mapped := make([][]*model.InlineButton, 0, len(keyboard))
+	for _, row := range keyboard {
+		mappedRow := make([]*model.InlineButton, 0, len(row))
+		for _, button := range row {
+			if !button.IsCallback() {
+				mappedRow = append(mappedRow, button)
+				continue
 			}
+			callbackID := utils.GenerateCallbackID()
+			mappedButton := *button
+			mappedButton.Data = callbackID
+			ses.RegisterCallback(callbackID,
+				button.CallbackBehaviour,
+				button.UserData,
+				button.CallbackHandlerRef,
+			)
+			ses.BindCallbacks(messageID, callbackID)
+			mappedRow = append(mappedRow, &mappedButton)
 		}
+		mapped = append(mapped, mappedRow)
 	}
+	return mapped
 }
 
-func (p *Processor) processTransit(stageReInit bool, ses *session.Session, update *model.UserUpdate) error {
+func (p *Processor) processTransit(ctx context.Context, stageReInit bool, ses *session.Session, update *model.UserUpdate) error {
 	currentStageRef := ses.GetCurrentStage()
 
 	if update.Transit != nil {
-		next, err := p.entityRegistry.GetStage(update.UserID, update.Transit.TargetRef)
+		targetRef := update.Transit.TargetRef
+		if update.Transit.Mode == model.TransitPop {
+			targetRef = currentStageRef
+			if prev, ok := ses.PreviousStage(); ok {
+				targetRef = prev
+			}
+		}
+		next, err := p.entityRegistry.GetStage(update.UserID, targetRef)
 		if err != nil {
 			return err
 		}
 
-		ses.SetNextStage(next.SelfRef())
-		initialMessages, err := p.initStage(ses, next)
+		if !currentStageRef.Empty() {
+			current, err := p.entityRegistry.GetStage(update.UserID, currentStageRef)
+			if err != nil {
+				return err
+			}
+			exitMessages, err := current.Exit(ses.UserContext)
+			if err != nil {
+				return err
+			}
+			update.Messages = append(update.Messages, exitMessages...)
+		}
+
+		switch update.Transit.Mode {
+		case model.TransitReplace:
+			ses.SetNextStage(next.SelfRef())
+		case model.TransitPop:
+			if _, ok := ses.PopStage(); !ok {
+				ses.SetNextStage(next.SelfRef())
+			}
+		default:
+			ses.PushStage(next.SelfRef())
+		}
+		ses.PendingInputs = make(map[string]model.ResourceRef)
+		enterMessages, err := next.Enter(ses.UserContext)
+		if err != nil {
+			return err
+		}
+		update.Messages = append(update.Messages, enterMessages...)
+
+		initialMessages, err := p.initStage(ctx, ses, next)
 		if err != nil {
 			return err
 		}
@@ -394,7 +804,16 @@ func (p *Processor) processTransit(stageReInit bool, ses *session.Session, updat
 		if err != nil {
 			return err
 		}
-		initialMessages, err := currentStage.Initializer().Init(update.UserID, currentStageRef)
+		if currentStage.ReinitSuppressed() {
+			return nil
+		}
+		reenterMessages, err := currentStage.Reenter(ses.UserContext)
+		if err != nil {
+			return err
+		}
+		update.Messages = append(update.Messages, reenterMessages...)
+
+		initialMessages, err := p.initStage(ctx, ses, currentStage)
 		if err != nil {
 			return err
 		}
@@ -403,18 +822,24 @@ func (p *Processor) processTransit(stageReInit bool, ses *session.Session, updat
 	return nil
 }
 
-func (p *Processor) initStage(ses *session.Session, stg *model.Stage) ([]*model.Message, error) {
-	ses.PendingInputs = make(map[string]model.ResourceRef)
-	initMessages, err := stg.Initialize(ses.UserID, stg.SelfRef())
+// initStage routes the reply buttons of the last initial message, the keyboard the user
+// sees. Pending inputs are left as they are when the initializer sends nothing.
+func (p *Processor) initStage(ctx context.Context, ses *session.Session, stg *model.Stage) ([]*model.Message, error) {
+	initMessages, err := stg.InitializeWithContext(ctx, ses.UserContext)
 	if err != nil {
 		return nil, err
 	}
+	if len(initMessages) == 0 {
+		return nil, nil
+	}
 
-	last := initMessages[len(initMessages)-1]
-	if initMessages[len(initMessages)-1] != nil {
+	ses.PendingInputs = make(map[string]model.ResourceRef)
+	if last := initMessages[len(initMessages)-1]; last != nil {
 		for _, row := range last.ReplyKeyboard {
 			for _, button := range row {
-				ses.PendingInputs[button.Text] = button.ActionRef
+				if key := button.InputKey(); key != "" {
+					ses.PendingInputs[key] = button.ActionRef
+				}
 			}
 		}
 	}
@@ -422,7 +847,7 @@ func (p *Processor) initStage(ses *session.Session, stg *model.Stage) ([]*model.
 	return initMessages, nil
 }
 
-func (p *Processor) respond(update *model.UserUpdate) ([]int, error) {
+func (p *Processor) respond(ses *session.Session, update *model.UserUpdate) ([]int, error) {
 	var sentMessages []int
 
 	if update.CallbackQueryResponse != nil {
@@ -433,28 +858,32 @@ func (p *Processor) respond(update *model.UserUpdate) ([]int, error) {
 		}
 	}
 
-	for _, msg := range update.Messages {
-		var chattables []tgbotapi.Chattable
-
-		if msg.Photo != nil {
-			photoConfig := utils.TransformPhoto(update.UserID, msg.Photo)
-			chattables = append(chattables, photoConfig)
-		}
-		if msg.Video != nil {
-			videoConfig := utils.TransformVideo(update.UserID, msg.Video)
-			chattables = append(chattables, videoConfig)
+	for _, edit := range update.Edits {
+		_, err := utils.Do(p.api, utils.TransformEdit(update.UserID, edit))
+		if err != nil && !utils.IsNotModifiedError(err) {
+			return sentMessages, err
 		}
-		mcgConfig := utils.TransformMessage(update.UserID, msg)
-		chattables = append(chattables, mcgConfig)
+	}
 
-		for _, c := range chattables {
-			sent, err := p.api.Send(c)
+	for _, msg := range update.Messages {
+		msg, keys := p.resolveCachedFiles(msg)
+		var lastSent int
+		for i, req := range utils.TransformMessageRequests(update.UserID, msg) {
+			sent, err := utils.DoMessages(p.api, req)
 			if err != nil {
 				return sentMessages, err
 			}
-			p.exporter.Increase(metrics.BotMessagesSentCountMetric)
-			sentMessages = append(sentMessages, sent.MessageID)
+			for _, m := range sent {
+				p.exporter.Increase(metrics.BotMessagesSentCountMetric)
+				sentMessages = append(sentMessages, m.MessageID)
+				lastSent = m.MessageID
+			}
+			if i == 0 && keys != nil {
+				p.cacheSentFiles(msg, keys, sent)
+			}
 		}
+		// the keyboard always goes with the last request of a message
+		ses.BindCallbacks(lastSent, inlineCallbackIDs(msg.InlineKeyboard)...)
 	}
 
 	for _, ID := range update.ToDeleteMessages {
@@ -462,6 +891,19 @@ func (p *Processor) respond(update *model.UserUpdate) ([]int, error) {
 		if err != nil {
 			return sentMessages, err
 		}
+		ses.DropMessageCallbacks(ID)
 	}
 	return sentMessages, nil
 }
+
+func inlineCallbackIDs(keyboard [][]*model.InlineButton) []string {
+	var ids []string
+	for _, row := range keyboard {
+		for _, button := range row {
+			if button.IsCallback() {
+				ids = append(ids, button.Data)
+			}
+		}
+	}
+	return ids
+}
@@ -2,11 +2,18 @@ package model
 
 import tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 
+// BackActionRef refers to the built-in action and callback handler returning the
+// user to the previous stage. Every registry created with entityregistry.New has them.
+const BackActionRef ResourceRef = "galaxia.back"
+
 type UserActionFunc func(ctx *UserContext, update *tgbotapi.Update) *UserUpdate
 
 type Action struct {
 	name string
 	fn   UserActionFunc
+
+	transitTargets   []ResourceRef
+	transitsDeclared bool
 }
 
 func NewAction(name string, actionFunc UserActionFunc) *Action {
@@ -23,3 +30,29 @@ func (s *Action) SelfRef() ResourceRef {
 func (s *Action) Func() UserActionFunc {
 	return s.fn
 }
+
+// WithTransitTargets declares the stages the action may move the user to, entry stages
+// of sub-flow calls included. Call it without targets for actions that only stay in
+// the stage or go back. Actions are plain functions, the targets only serve registry
+// validation and graph export.
+func (s *Action) WithTransitTargets(targets ...ResourceRef) *Action {
+	s.transitTargets = append(s.transitTargets, targets...)
+	s.transitsDeclared = true
+	return s
+}
+
+func (s *Action) TransitTargets() []ResourceRef {
+	return s.transitTargets
+}
+
+// TransitsDeclared reports whether WithTransitTargets was called, the transits of other
+// actions are decided by their code.
+func (s *Action) TransitsDeclared() bool {
+	return s.transitsDeclared
+}
+
+func NewBackAction() *Action {
+	return NewAction(string(BackActionRef), func(ctx *UserContext, _ *tgbotapi.Update) *UserUpdate {
+		return NewUserUpdate(ctx.UserID, WithTransitPop(false))
+	}).WithTransitTargets()
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxDeepLinkPayloadLength is the longest start parameter Telegram passes to the bot.
+const MaxDeepLinkPayloadLength = 64
+
+var (
+	InvalidArgumentsError = errors.New("invalid command arguments")
+	deepLinkPayloadRe     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+type ArgType int
+
+const (
+	StringArg ArgType = iota
+	IntArg
+	FloatArg
+	BoolArg
+	// DurationArg accepts time.ParseDuration values such as 10m or 1h30m.
+	DurationArg
+	// TextArg takes the rest of the arguments as is, it has to be the last argument.
+	TextArg
+	// PayloadArg is a raw deep-link payload as passed by t.me/<bot>?start=<payload>.
+	PayloadArg
+	// Base64PayloadArg is a deep-link payload encoded with EncodeDeepLinkPayload.
+	Base64PayloadArg
+)
+
+// ArgSpec declares a positional command argument. Parsed values are strings for
+// string, text and payload arguments, int64, float64, bool or time.Duration otherwise.
+type ArgSpec struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+}
+
+func NewArg(name string, argType ArgType) *ArgSpec {
+	return &ArgSpec{
+		Name: name,
+		Type: argType,
+	}
+}
+
+// NewOptionalArg declares an argument that may be left out, only trailing arguments can be optional.
+func NewOptionalArg(name string, argType ArgType) *ArgSpec {
+	return &ArgSpec{
+		Name:     name,
+		Type:     argType,
+		Optional: true,
+	}
+}
+
+func (a *ArgSpec) parse(token string) (interface{}, error) {
+	switch a.Type {
+	case IntArg:
+		return strconv.ParseInt(token, 10, 64)
+	case FloatArg:
+		return strconv.ParseFloat(strings.Replace(token, ",", ".", 1), 64)
+	case BoolArg:
+		return strconv.ParseBool(strings.ToLower(token))
+	case DurationArg:
+		return time.ParseDuration(token)
+	case PayloadArg:
+		if len(token) > MaxDeepLinkPayloadLength || !deepLinkPayloadRe.MatchString(token) {
+			return nil, errors.New("malformed deep-link payload")
+		}
+		return token, nil
+	case Base64PayloadArg:
+		data, err := DecodeDeepLinkPayload(token)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	default:
+		return token, nil
+	}
+}
+
+func (a *ArgSpec) usage() string {
+	if a.Optional {
+		return "[" + a.Name + "]"
+	}
+	return "<" + a.Name + ">"
+}
+
+// ParseArgs splits the command arguments on whitespace, double quotes group words
+// into a single argument, and parses them by specs. Missing optional arguments are
+// left out of the result.
+func ParseArgs(specs []*ArgSpec, input string) (map[string]interface{}, error) {
+	tokens := tokenizeArgs(input)
+	args := make(map[string]interface{}, len(specs))
+	for i, spec := range specs {
+		if i >= len(tokens) {
+			if !spec.Optional {
+				return nil, fmt.Errorf("%w: %s is missing", InvalidArgumentsError, spec.Name)
+			}
+			continue
+		}
+		if spec.Type == TextArg {
+			args[spec.Name] = strings.TrimSpace(input[tokens[i].offset:])
+			return args, nil
+		}
+		value, err := spec.parse(tokens[i].value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", InvalidArgumentsError, spec.Name, err)
+		}
+		args[spec.Name] = value
+	}
+	if len(tokens) > len(specs) {
+		return nil, fmt.Errorf("%w: expected at most %d arguments", InvalidArgumentsError, len(specs))
+	}
+	return args, nil
+}
+
+type argToken struct {
+	value  string
+	offset int
+}
+
+func tokenizeArgs(input string) []argToken {
+	var tokens []argToken
+	var current strings.Builder
+	inToken, quoted := false, false
+	offset := 0
+	for i, r := range input {
+		switch {
+		case r == '"':
+			if !inToken {
+				inToken, offset = true, i
+			}
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if inToken {
+				tokens = append(tokens, argToken{value: current.String(), offset: offset})
+				current.Reset()
+				inToken = false
+			}
+		default:
+			if !inToken {
+				inToken, offset = true, i
+			}
+			current.WriteRune(r)
+		}
+	}
+	if inToken {
+		tokens = append(tokens, argToken{value: current.String(), offset: offset})
+	}
+	return tokens
+}
+
+// EncodeDeepLinkPayload encodes data with unpadded base64url so it fits the start
+// parameter alphabet.
+func EncodeDeepLinkPayload(data []byte) (string, error) {
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	if len(payload) > MaxDeepLinkPayloadLength {
+		return "", fmt.Errorf("deep-link payload is %d characters long, at most %d are allowed", len(payload), MaxDeepLinkPayloadLength)
+	}
+	return payload, nil
+}
+
+// DecodeDeepLinkPayload decodes a base64url payload, with or without padding.
+func DecodeDeepLinkPayload(payload string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(payload, "="))
+}
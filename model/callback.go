@@ -4,6 +4,10 @@ type PendingCallback struct {
 	UserData   string            `json:"userData,omitempty"`
 	HandlerRef ResourceRef       `json:"handler_ref" json:"handlerRef,omitempty"`
 	Behaviour  CallbackBehaviour `json:"behaviour" json:"behaviour,omitempty"`
+	MessageID  int               `json:"message_id,omitempty"`
+
+	// Page is set for multi page keyboard navigation buttons and holds the page to show.
+	Page *MultiPageInlineKeyboard `json:"page,omitempty"`
 }
 
 type CallbackHandler struct {
@@ -25,3 +29,7 @@ func (c *CallbackHandler) SelfRef() ResourceRef {
 func (c *CallbackHandler) ActionRef() ResourceRef {
 	return c.actionRef
 }
+
+func NewBackCallbackHandler() *CallbackHandler {
+	return NewCallbackHandler(string(BackActionRef), BackActionRef)
+}
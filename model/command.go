@@ -1,15 +1,126 @@
 package model
 
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	CommandNotAllowedError = errors.New("command not allowed")
+	menuCommandRe          = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+)
+
+// CommandVisibility decides in which command menus a command is listed, hidden
+// commands still run when typed.
+type CommandVisibility int
+
+const (
+	VisibleCommand CommandVisibility = iota
+	PrivateChatCommand
+	GroupChatCommand
+	// AdminCommand is listed only for administrators of group chats.
+	AdminCommand
+	HiddenCommand
+)
+
 type Command struct {
-	name      string
-	actionRef ResourceRef
+	name         string
+	actionRef    ResourceRef
+	description  string
+	descriptions map[string]string
+	aliases      []string
+	args         []*ArgSpec
+	usage        string
+	visibility   CommandVisibility
 }
 
-func NewCommand(name string, actionRef ResourceRef) *Command {
-	return &Command{
+type CommandOption func(*Command)
+
+func NewCommand(name string, actionRef ResourceRef, opts ...CommandOption) *Command {
+	c := &Command{
 		name:      name,
 		actionRef: actionRef,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithCommandDescription sets the text shown next to the command in the Telegram command menu.
+func WithCommandDescription(description string) CommandOption {
+	return func(c *Command) {
+		c.description = description
+	}
+}
+
+func (c *Command) WithDescription(description string) *Command {
+	c.description = description
+	return c
+}
+
+func WithCommandLocalizedDescription(lang, description string) CommandOption {
+	return func(c *Command) {
+		c.WithLocalizedDescription(lang, description)
+	}
+}
+
+func (c *Command) WithLocalizedDescription(lang, description string) *Command {
+	if c.descriptions == nil {
+		c.descriptions = make(map[string]string)
+	}
+	c.descriptions[strings.ToLower(lang)] = description
+	return c
+}
+
+// WithCommandAliases adds names the command answers to besides its own, such as h
+// and ? for help. Aliases are never shown in the command menu.
+func WithCommandAliases(aliases ...string) CommandOption {
+	return func(c *Command) {
+		c.aliases = append(c.aliases, aliases...)
+	}
+}
+
+func (c *Command) WithAliases(aliases ...string) *Command {
+	c.aliases = append(c.aliases, aliases...)
+	return c
+}
+
+func WithCommandVisibility(visibility CommandVisibility) CommandOption {
+	return func(c *Command) {
+		c.visibility = visibility
+	}
+}
+
+func (c *Command) WithVisibility(visibility CommandVisibility) *Command {
+	c.visibility = visibility
+	return c
+}
+
+// WithCommandArgs declares the arguments parsed before the command action runs.
+func WithCommandArgs(args ...*ArgSpec) CommandOption {
+	return func(c *Command) {
+		c.args = append(c.args, args...)
+	}
+}
+
+func (c *Command) WithArgs(args ...*ArgSpec) *Command {
+	c.args = append(c.args, args...)
+	return c
+}
+
+// WithCommandUsage replaces the generated usage message sent when the arguments don't parse.
+func WithCommandUsage(usage string) CommandOption {
+	return func(c *Command) {
+		c.usage = usage
+	}
+}
+
+func (c *Command) WithUsage(usage string) *Command {
+	c.usage = usage
+	return c
 }
 
 func (c *Command) SelfRef() ResourceRef {
@@ -19,3 +130,147 @@ func (c *Command) SelfRef() ResourceRef {
 func (c *Command) ActionRef() ResourceRef {
 	return c.actionRef
 }
+
+// Description falls back to the command name, the menu doesn't accept empty descriptions.
+func (c *Command) Description() string {
+	if c.description == "" {
+		return c.name
+	}
+	return c.description
+}
+
+// LocalizedDescription returns the description for lang, falling back from a regional
+// code such as pt-br to pt and then to the default description.
+func (c *Command) LocalizedDescription(lang string) string {
+	lang = strings.ToLower(lang)
+	if description, ok := c.descriptions[lang]; ok {
+		return description
+	}
+	if base, _, found := strings.Cut(lang, "-"); found {
+		if description, ok := c.descriptions[base]; ok {
+			return description
+		}
+	}
+	return c.Description()
+}
+
+// Languages returns the language codes the command has descriptions for.
+func (c *Command) Languages() []string {
+	langs := make([]string, 0, len(c.descriptions))
+	for lang := range c.descriptions {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+func (c *Command) Aliases() []string {
+	return c.aliases
+}
+
+// Matches reports whether name is the command name or one of its aliases.
+func (c *Command) Matches(name string, foldCase bool) bool {
+	for _, candidate := range append([]string{c.name}, c.aliases...) {
+		if candidate == name || foldCase && strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Command) Visibility() CommandVisibility {
+	return c.visibility
+}
+
+// Listed reports whether the command can be shown in the command menu, which only
+// accepts lowercase latin letters, digits and underscores.
+func (c *Command) Listed() bool {
+	return c.visibility != HiddenCommand && menuCommandRe.MatchString(c.name)
+}
+
+// ListedIn reports whether the command is shown in the menus of the scope type. Chat
+// scopes list commands like the private or group chat scopes depending on the chat.
+func (c *Command) ListedIn(scopeType CommandScopeType) bool {
+	if !c.Listed() {
+		return false
+	}
+	switch c.visibility {
+	case PrivateChatCommand:
+		return scopeType == AllPrivateChatsCommandScope
+	case GroupChatCommand:
+		return scopeType == AllGroupChatsCommandScope || scopeType == AllChatAdministratorsCommandScope
+	case AdminCommand:
+		return scopeType == AllChatAdministratorsCommandScope
+	default:
+		return true
+	}
+}
+
+// BotCommand returns the command menu entry with the description for lang.
+func (c *Command) BotCommand(lang string) BotCommand {
+	return BotCommand{
+		Command:     c.name,
+		Description: c.LocalizedDescription(lang),
+	}
+}
+
+func (c *Command) Args() []*ArgSpec {
+	return c.args
+}
+
+// ParseArgs parses the command arguments by the declared specs, the arguments of
+// commands without specs are ignored.
+func (c *Command) ParseArgs(input string) (map[string]interface{}, error) {
+	if len(c.args) == 0 {
+		return nil, nil
+	}
+	return ParseArgs(c.args, input)
+}
+
+func (c *Command) Usage() string {
+	if c.usage != "" {
+		return c.usage
+	}
+	parts := []string{"/" + c.name}
+	for _, arg := range c.args {
+		parts = append(parts, arg.usage())
+	}
+	return "Usage: " + strings.Join(parts, " ")
+}
+
+// BotCommand is an entry of the Telegram command menu.
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+type CommandScopeType string
+
+const (
+	DefaultCommandScope               CommandScopeType = "default"
+	AllPrivateChatsCommandScope       CommandScopeType = "all_private_chats"
+	AllGroupChatsCommandScope         CommandScopeType = "all_group_chats"
+	AllChatAdministratorsCommandScope CommandScopeType = "all_chat_administrators"
+	ChatCommandScope                  CommandScopeType = "chat"
+	ChatAdministratorsCommandScope    CommandScopeType = "chat_administrators"
+	ChatMemberCommandScope            CommandScopeType = "chat_member"
+)
+
+// CommandScope selects the chats and users a command menu is published for.
+type CommandScope struct {
+	Type   CommandScopeType `json:"type"`
+	ChatID int64            `json:"chat_id,omitempty"`
+	UserID int64            `json:"user_id,omitempty"`
+}
+
+func NewCommandScope(scopeType CommandScopeType) *CommandScope {
+	return &CommandScope{Type: scopeType}
+}
+
+func NewChatCommandScope(chatID int64) *CommandScope {
+	return &CommandScope{Type: ChatCommandScope, ChatID: chatID}
+}
+
+func NewChatMemberCommandScope(chatID, userID int64) *CommandScope {
+	return &CommandScope{Type: ChatMemberCommandScope, ChatID: chatID, UserID: userID}
+}
@@ -8,5 +8,13 @@ type UserContext struct {
 	Username string                 `json:"username,omitempty"`
 	Misc     map[string]interface{} `json:"misc,omitempty"`
 
-	CallbackData *string
+	CallbackData      *string
+	CallbackMessageID int
+
+	// FlowParams holds the parameters of the sub-flow the user is in.
+	FlowParams map[string]interface{} `json:"-"`
+	// FlowResult is only set while the continuation action of a sub-flow runs.
+	FlowResult map[string]interface{} `json:"-"`
+	// CommandArgs holds the parsed arguments while the action of a command runs.
+	CommandArgs map[string]interface{} `json:"-"`
 }
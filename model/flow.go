@@ -0,0 +1,55 @@
+package model
+
+// FlowCall starts a sub-flow: the user is moved to the entry stage and once a stage
+// of the sub-flow answers with WithReturn the continuation action gets the result.
+type FlowCall struct {
+	EntryRef        ResourceRef
+	ContinuationRef ResourceRef
+	Params          map[string]interface{}
+	Clean           bool
+}
+
+type FlowReturn struct {
+	Result map[string]interface{}
+	Clean  bool
+}
+
+// FlowFrame is the return address of a called sub-flow. It keeps the caller stage
+// state, so returning restores it without initializing the caller stage again.
+type FlowFrame struct {
+	ReturnStage     ResourceRef            `json:"return_stage"`
+	ContinuationRef ResourceRef            `json:"continuation_ref"`
+	Params          map[string]interface{} `json:"params,omitempty"`
+	StageHistory    []ResourceRef          `json:"stage_history,omitempty"`
+	PendingInputs   map[string]ResourceRef `json:"pending_inputs,omitempty"`
+}
+
+// WithCall calls the sub-flow starting at entryStageRef. Params are available to the
+// sub-flow stages as UserContext.FlowParams. They are persisted with the session, so
+// they have to be JSON compatible: nil, bool, numbers, string, []interface{} and
+// map[string]interface{}. Numbers come back as float64 once the session is reloaded,
+// other types like time.Time or []string make the call fail.
+func WithCall(entryStageRef ResourceRef, continuationRef ResourceRef, params map[string]interface{}, clean bool) UserUpdateOption {
+	return func(response *UserUpdate) {
+		response.Call = &FlowCall{
+			EntryRef:        entryStageRef,
+			ContinuationRef: continuationRef,
+			Params:          params,
+			Clean:           clean,
+		}
+	}
+}
+
+// WithReturn leaves the current sub-flow. The continuation action of the caller reads
+// the result from UserContext.FlowResult, it gets a synthetic tgbotapi update carrying
+// only the chat and sender ids of the user. The caller stage is not initialized again,
+// returns can't be combined with transits or calls.
+func WithReturn(result map[string]interface{}, clean bool) UserUpdateOption {
+	return func(response *UserUpdate) {
+		response.Return = &FlowReturn{
+			Result: result,
+			Clean:  clean,
+		}
+		response.NoReinit = true
+	}
+}
@@ -0,0 +1,203 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+type ParseMode string
+
+const (
+	PlainText  ParseMode = ""
+	HTML       ParseMode = "HTML"
+	MarkdownV2 ParseMode = "MarkdownV2"
+)
+
+var (
+	htmlEscaper       = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+	markdownEscaper   = newMarkdownEscaper("_*[]()~`>#+-=|{}.!\\")
+	markdownCodeEsc   = newMarkdownEscaper("`\\")
+	markdownLinkEsc   = newMarkdownEscaper(")\\")
+	markdownEntityFmt = map[string]string{
+		"bold":          "*",
+		"italic":        "_",
+		"underline":     "__",
+		"strikethrough": "~",
+		"spoiler":       "||",
+	}
+	htmlEntityTag = map[string]string{
+		"bold":          "b",
+		"italic":        "i",
+		"underline":     "u",
+		"strikethrough": "s",
+		"spoiler":       "tg-spoiler",
+	}
+)
+
+func newMarkdownEscaper(chars string) *strings.Replacer {
+	var pairs []string
+	for _, c := range chars {
+		pairs = append(pairs, string(c), "\\"+string(c))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Escape makes s safe to embed as plain text into a message of the given parse mode.
+func Escape(mode ParseMode, s string) string {
+	switch mode {
+	case HTML:
+		return htmlEscaper.Replace(s)
+	case MarkdownV2:
+		return markdownEscaper.Replace(s)
+	default:
+		return s
+	}
+}
+
+func wrapEntity(mode ParseMode, entity string, s string) string {
+	switch mode {
+	case HTML:
+		tag := htmlEntityTag[entity]
+		return "<" + tag + ">" + Escape(mode, s) + "</" + tag + ">"
+	case MarkdownV2:
+		marker := markdownEntityFmt[entity]
+		return marker + Escape(mode, s) + marker
+	default:
+		return s
+	}
+}
+
+func Bold(mode ParseMode, s string) string {
+	return wrapEntity(mode, "bold", s)
+}
+
+func Italic(mode ParseMode, s string) string {
+	return wrapEntity(mode, "italic", s)
+}
+
+func Underline(mode ParseMode, s string) string {
+	return wrapEntity(mode, "underline", s)
+}
+
+func Strikethrough(mode ParseMode, s string) string {
+	return wrapEntity(mode, "strikethrough", s)
+}
+
+func Spoiler(mode ParseMode, s string) string {
+	return wrapEntity(mode, "spoiler", s)
+}
+
+func Code(mode ParseMode, s string) string {
+	switch mode {
+	case HTML:
+		return "<code>" + Escape(mode, s) + "</code>"
+	case MarkdownV2:
+		return "`" + markdownCodeEsc.Replace(s) + "`"
+	default:
+		return s
+	}
+}
+
+func Pre(mode ParseMode, s string, language string) string {
+	switch mode {
+	case HTML:
+		if language == "" {
+			return "<pre>" + Escape(mode, s) + "</pre>"
+		}
+		return `<pre><code class="language-` + Escape(mode, language) + `">` + Escape(mode, s) + "</code></pre>"
+	case MarkdownV2:
+		return "```" + language + "\n" + markdownCodeEsc.Replace(s) + "\n```"
+	default:
+		return s
+	}
+}
+
+func Link(mode ParseMode, text string, url string) string {
+	switch mode {
+	case HTML:
+		return `<a href="` + Escape(mode, url) + `">` + Escape(mode, text) + "</a>"
+	case MarkdownV2:
+		return "[" + Escape(mode, text) + "](" + markdownLinkEsc.Replace(url) + ")"
+	default:
+		return text + " (" + url + ")"
+	}
+}
+
+func Mention(mode ParseMode, text string, userID int64) string {
+	if mode == PlainText {
+		return text
+	}
+	return Link(mode, text, "tg://user?id="+strconv.FormatInt(userID, 10))
+}
+
+// TextBuilder assembles formatted message text, escaping every user supplied piece.
+type TextBuilder struct {
+	mode ParseMode
+	buf  strings.Builder
+}
+
+func NewTextBuilder(mode ParseMode) *TextBuilder {
+	return &TextBuilder{
+		mode: mode,
+	}
+}
+
+func (b *TextBuilder) ParseMode() ParseMode {
+	return b.mode
+}
+
+func (b *TextBuilder) Text(s string) *TextBuilder {
+	b.buf.WriteString(Escape(b.mode, s))
+	return b
+}
+
+// Raw appends s as is, it must already be valid markup for the builder parse mode.
+func (b *TextBuilder) Raw(s string) *TextBuilder {
+	b.buf.WriteString(s)
+	return b
+}
+
+func (b *TextBuilder) Bold(s string) *TextBuilder {
+	return b.Raw(Bold(b.mode, s))
+}
+
+func (b *TextBuilder) Italic(s string) *TextBuilder {
+	return b.Raw(Italic(b.mode, s))
+}
+
+func (b *TextBuilder) Underline(s string) *TextBuilder {
+	return b.Raw(Underline(b.mode, s))
+}
+
+func (b *TextBuilder) Strikethrough(s string) *TextBuilder {
+	return b.Raw(Strikethrough(b.mode, s))
+}
+
+func (b *TextBuilder) Spoiler(s string) *TextBuilder {
+	return b.Raw(Spoiler(b.mode, s))
+}
+
+func (b *TextBuilder) Code(s string) *TextBuilder {
+	return b.Raw(Code(b.mode, s))
+}
+
+func (b *TextBuilder) Pre(s string, language string) *TextBuilder {
+	return b.Raw(Pre(b.mode, s, language))
+}
+
+func (b *TextBuilder) Link(text string, url string) *TextBuilder {
+	return b.Raw(Link(b.mode, text, url))
+}
+
+func (b *TextBuilder) Mention(text string, userID int64) *TextBuilder {
+	return b.Raw(Mention(b.mode, text, userID))
+}
+
+func (b *TextBuilder) Line() *TextBuilder {
+	b.buf.WriteString("\n")
+	return b
+}
+
+func (b *TextBuilder) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+type ContentType string
+
+const (
+	UnknownContent   ContentType = ""
+	TextContent      ContentType = "text"
+	PhotoContent     ContentType = "photo"
+	VideoContent     ContentType = "video"
+	DocumentContent  ContentType = "document"
+	AudioContent     ContentType = "audio"
+	VoiceContent     ContentType = "voice"
+	VideoNoteContent ContentType = "video_note"
+	AnimationContent ContentType = "animation"
+	StickerContent   ContentType = "sticker"
+	ContactContent   ContentType = "contact"
+	LocationContent  ContentType = "location"
+)
+
+// MessageContentType reports the kind of payload carried by an incoming message.
+// Animations are checked before documents since Telegram sets both for GIFs.
+func MessageContentType(msg *tgbotapi.Message) ContentType {
+	switch {
+	case msg == nil:
+		return UnknownContent
+	case msg.Photo != nil:
+		return PhotoContent
+	case msg.Video != nil:
+		return VideoContent
+	case msg.Animation != nil:
+		return AnimationContent
+	case msg.Document != nil:
+		return DocumentContent
+	case msg.Audio != nil:
+		return AudioContent
+	case msg.Voice != nil:
+		return VoiceContent
+	case msg.VideoNote != nil:
+		return VideoNoteContent
+	case msg.Sticker != nil:
+		return StickerContent
+	case msg.Contact != nil:
+		return ContactContent
+	case msg.Location != nil:
+		return LocationContent
+	case msg.Text != "":
+		return TextContent
+	}
+	return UnknownContent
+}
+
+// MessageInput returns the user supplied text of a message, falling back to the media caption.
+func MessageInput(msg *tgbotapi.Message) string {
+	if msg == nil {
+		return ""
+	}
+	if msg.Text != "" {
+		return msg.Text
+	}
+	return msg.Caption
+}
+
+// SharedInputKey is the pending input key of non text content shared with a reply
+// button. It starts with a NUL byte so typed text can't collide with it.
+func SharedInputKey(contentType ContentType) string {
+	return "\x00" + string(contentType)
+}
+
+// MessageInputKey returns the pending input key an incoming message is matched by.
+func MessageInputKey(msg *tgbotapi.Message) string {
+	switch contentType := MessageContentType(msg); contentType {
+	case ContactContent, LocationContent:
+		return SharedInputKey(contentType)
+	case UnknownContent:
+		return ""
+	default:
+		return msg.Text
+	}
+}
+
+type InputPredicate func(ctx *UserContext, update *tgbotapi.Update) bool
+
+// InputRoute binds a stage input matcher to an action.
+// Routes of a stage are evaluated in order after exact keyboard button matches.
+type InputRoute struct {
+	actionRef ResourceRef
+	match     InputPredicate
+}
+
+func NewRegexInputRoute(re *regexp.Regexp, actionRef ResourceRef) *InputRoute {
+	return NewPredicateInputRoute(func(_ *UserContext, update *tgbotapi.Update) bool {
+		return re.MatchString(MessageInput(update.Message))
+	}, actionRef)
+}
+
+func NewPrefixInputRoute(prefix string, actionRef ResourceRef) *InputRoute {
+	return NewPredicateInputRoute(func(_ *UserContext, update *tgbotapi.Update) bool {
+		return strings.HasPrefix(MessageInput(update.Message), prefix)
+	}, actionRef)
+}
+
+func NewContentTypeInputRoute(actionRef ResourceRef, types ...ContentType) *InputRoute {
+	return NewPredicateInputRoute(func(_ *UserContext, update *tgbotapi.Update) bool {
+		contentType := MessageContentType(update.Message)
+		for _, t := range types {
+			if t == contentType {
+				return true
+			}
+		}
+		return false
+	}, actionRef)
+}
+
+func NewPredicateInputRoute(predicate InputPredicate, actionRef ResourceRef) *InputRoute {
+	return &InputRoute{
+		actionRef: actionRef,
+		match:     predicate,
+	}
+}
+
+func (r *InputRoute) ActionRef() ResourceRef {
+	return r.actionRef
+}
+
+func (r *InputRoute) Match(ctx *UserContext, update *tgbotapi.Update) bool {
+	if update.Message == nil || r.match == nil {
+		return false
+	}
+	return r.match(ctx, update)
+}
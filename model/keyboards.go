@@ -1,5 +1,10 @@
 package model
 
+import (
+	"errors"
+	"fmt"
+)
+
 type KeyboardLayout int
 
 const (
@@ -18,9 +23,49 @@ const (
 	DeleteCallbackBehaviour
 )
 
+type ReplyButtonKind int
+
+const (
+	TextReplyButton ReplyButtonKind = iota
+	ContactReplyButton
+	LocationReplyButton
+	PollReplyButton
+	UsersReplyButton
+	ChatReplyButton
+)
+
+type PollType string
+
+const (
+	AnyPoll     PollType = ""
+	QuizPoll    PollType = "quiz"
+	RegularPoll PollType = "regular"
+)
+
+type UsersRequest struct {
+	RequestID     int   `json:"request_id"`
+	UserIsBot     *bool `json:"user_is_bot,omitempty"`
+	UserIsPremium *bool `json:"user_is_premium,omitempty"`
+	MaxQuantity   int   `json:"max_quantity,omitempty"`
+}
+
+type ChatRequest struct {
+	RequestID       int   `json:"request_id"`
+	ChatIsChannel   bool  `json:"chat_is_channel"`
+	ChatIsForum     *bool `json:"chat_is_forum,omitempty"`
+	ChatHasUsername *bool `json:"chat_has_username,omitempty"`
+	ChatIsCreated   *bool `json:"chat_is_created,omitempty"`
+	BotIsMember     bool  `json:"bot_is_member,omitempty"`
+}
+
 type ReplyButton struct {
+	Kind      ReplyButtonKind
 	Text      string
 	ActionRef ResourceRef
+
+	PollType     PollType
+	UsersRequest *UsersRequest
+	ChatRequest  *ChatRequest
 }
 
 func NewReplyButton(name string) *ReplyButton {
@@ -29,18 +74,118 @@ func NewReplyButton(name string) *ReplyButton {
 	}
 }
 
+// NewContactButton asks the user to share their phone number, the shared contact
+// is routed to the linked action.
+func NewContactButton(name string) *ReplyButton {
+	return &ReplyButton{
+		Kind: ContactReplyButton,
+		Text: name,
+	}
+}
+
+// NewLocationButton asks the user to share their location, the shared location
+// is routed to the linked action.
+func NewLocationButton(name string) *ReplyButton {
+	return &ReplyButton{
+		Kind: LocationReplyButton,
+		Text: name,
+	}
+}
+
+func NewPollButton(name string, pollType PollType) *ReplyButton {
+	return &ReplyButton{
+		Kind:     PollReplyButton,
+		Text:     name,
+		PollType: pollType,
+	}
+}
+
+func NewUsersButton(name string, request *UsersRequest) *ReplyButton {
+	return &ReplyButton{
+		Kind:         UsersReplyButton,
+		Text:         name,
+		UsersRequest: request,
+	}
+}
+
+func NewChatButton(name string, request *ChatRequest) *ReplyButton {
+	return &ReplyButton{
+		Kind:        ChatReplyButton,
+		Text:        name,
+		ChatRequest: request,
+	}
+}
+
+// NewBackReplyButton returns the user to the previous stage.
+func NewBackReplyButton(name string) *ReplyButton {
+	return NewReplyButton(name).LinkAction(BackActionRef)
+}
+
 func (b *ReplyButton) LinkAction(actionRef ResourceRef) *ReplyButton {
 	b.ActionRef = actionRef
 	return b
 }
 
+// InputKey returns the pending input key the button press is matched by.
+// Polls, users and chats shared by the user can't be decoded by the underlying
+// Telegram client, so such buttons have no key and are not routed.
+func (b *ReplyButton) InputKey() string {
+	switch b.Kind {
+	case TextReplyButton:
+		return b.Text
+	case ContactReplyButton:
+		return SharedInputKey(ContactContent)
+	case LocationReplyButton:
+		return SharedInputKey(LocationContent)
+	default:
+		return ""
+	}
+}
+
+type InlineButtonKind int
+
+const (
+	CallbackButton InlineButtonKind = iota
+	URLButton
+	SwitchInlineQueryButton
+	SwitchInlineQueryCurrentChatButton
+	SwitchInlineQueryChosenChatButton
+	LoginURLButton
+	WebAppButton
+	CopyTextButton
+	PayButton
+)
+
+type SwitchInlineQueryChosenChat struct {
+	Query             string `json:"query,omitempty"`
+	AllowUserChats    bool   `json:"allow_user_chats,omitempty"`
+	AllowBotChats     bool   `json:"allow_bot_chats,omitempty"`
+	AllowGroupChats   bool   `json:"allow_group_chats,omitempty"`
+	AllowChannelChats bool   `json:"allow_channel_chats,omitempty"`
+}
+
+type LoginURL struct {
+	URL                string `json:"url"`
+	ForwardText        string `json:"forward_text,omitempty"`
+	BotUsername        string `json:"bot_username,omitempty"`
+	RequestWriteAccess bool   `json:"request_write_access,omitempty"`
+}
+
 type InlineButton struct {
+	Kind     InlineButtonKind
 	Text     string
 	Data     string
 	UserData string
 
 	CallbackBehaviour  CallbackBehaviour
 	CallbackHandlerRef ResourceRef
+
+	// URL holds the link of URL and web app buttons.
+	URL string
+	// Query holds the inline query of switch buttons and the text of copy buttons.
+	Query      string
+	ChosenChat *SwitchInlineQueryChosenChat
+	LoginURL   *LoginURL
 }
 
 func NewInlineButton(name string) *InlineButton {
@@ -49,6 +194,81 @@ func NewInlineButton(name string) *InlineButton {
 	}
 }
 
+// NewBackInlineButton returns the user to the previous stage.
+func NewBackInlineButton(name string) *InlineButton {
+	return NewInlineButton(name).LinkCallbackHandler(BackActionRef)
+}
+
+func NewURLButton(name string, url string) *InlineButton {
+	return &InlineButton{
+		Kind: URLButton,
+		Text: name,
+		URL:  url,
+	}
+}
+
+// NewSwitchInlineQueryButton prompts the user to pick a chat and inserts the bot username with query there.
+func NewSwitchInlineQueryButton(name string, query string) *InlineButton {
+	return &InlineButton{
+		Kind:  SwitchInlineQueryButton,
+		Text:  name,
+		Query: query,
+	}
+}
+
+func NewSwitchInlineQueryCurrentChatButton(name string, query string) *InlineButton {
+	return &InlineButton{
+		Kind:  SwitchInlineQueryCurrentChatButton,
+		Text:  name,
+		Query: query,
+	}
+}
+
+func NewSwitchInlineQueryChosenChatButton(name string, chosenChat *SwitchInlineQueryChosenChat) *InlineButton {
+	return &InlineButton{
+		Kind:       SwitchInlineQueryChosenChatButton,
+		Text:       name,
+		ChosenChat: chosenChat,
+	}
+}
+
+func NewLoginURLButton(name string, loginURL *LoginURL) *InlineButton {
+	return &InlineButton{
+		Kind:     LoginURLButton,
+		Text:     name,
+		LoginURL: loginURL,
+	}
+}
+
+func NewWebAppButton(name string, url string) *InlineButton {
+	return &InlineButton{
+		Kind: WebAppButton,
+		Text: name,
+		URL:  url,
+	}
+}
+
+func NewCopyTextButton(name string, text string) *InlineButton {
+	return &InlineButton{
+		Kind:  CopyTextButton,
+		Text:  name,
+		Query: text,
+	}
+}
+
+// NewPayButton must be the first button of the first row and is only valid on invoices.
+func NewPayButton(name string) *InlineButton {
+	return &InlineButton{
+		Kind: PayButton,
+		Text: name,
+	}
+}
+
+// IsCallback reports whether pressing the button sends a callback query to the bot.
+func (b *InlineButton) IsCallback() bool {
+	return b.Kind == CallbackButton
+}
+
 func (b *InlineButton) LinkCallbackHandler(handlerRef ResourceRef) *InlineButton {
 	b.CallbackHandlerRef = handlerRef
 	return b
@@ -59,44 +279,300 @@ func (b *InlineButton) LinkUserData(data string) *InlineButton {
 	return b
 }
 
+const (
+	MaxInlineButtonsPerRow = 8
+	MaxInlineButtons       = 100
+	MaxReplyButtonsPerRow  = 12
+	MaxReplyButtons        = 300
+
+	MaxInputFieldPlaceholderLength = 64
+)
+
+var InvalidKeyboardError = errors.New("invalid keyboard")
+
+// ButtonsPerRow returns the row width of fixed layouts and zero for Custom.
+func (l KeyboardLayout) ButtonsPerRow() int {
+	switch l {
+	case OnePerRow:
+		return 1
+	case TwoPerRow:
+		return 2
+	case ThreePerRow:
+		return 3
+	case FourPerRow:
+		return 4
+	case FivePerRow:
+		return 5
+	default:
+		return 0
+	}
+}
+
+// NewKeyboard lays buttons out with a fixed layout. Custom layouts need a row spec,
+// use NewCustomKeyboard or ArrangeKeyboard for them, here they fall back to one per row.
 func NewKeyboard[T any](layout KeyboardLayout, buttons ...T) [][]T {
+	perRow := layout.ButtonsPerRow()
+	if perRow == 0 {
+		perRow = 1
+	}
 	var keyboard [][]T
-	switch layout {
-	case OnePerRow:
-		for _, button := range buttons {
-			keyboard = append(keyboard, []T{button})
+	for i := 0; i < len(buttons); i += perRow {
+		end := i + perRow
+		if end > len(buttons) {
+			end = len(buttons)
 		}
-	case TwoPerRow:
-		for i := 0; i < len(buttons); i += 2 {
-			if i+1 >= len(buttons) {
-				keyboard = append(keyboard, buttons[i:])
-				break
+		keyboard = append(keyboard, buttons[i:end])
+	}
+	return keyboard
+}
+
+// NewCustomKeyboard lays buttons out by an explicit row spec, e.g. []int{3, 2, 1}.
+// Specs with empty rows or fewer places than buttons are rejected.
+func NewCustomKeyboard[T any](rows []int, buttons ...T) ([][]T, error) {
+	return ArrangeKeyboard(NewCustomGrid(rows...), buttons...)
+}
+
+// KeyboardGrid describes how buttons are distributed into rows, Rows is used by Custom layouts only.
+type KeyboardGrid struct {
+	Layout KeyboardLayout
+	Rows   []int
+}
+
+func NewGrid(layout KeyboardLayout) KeyboardGrid {
+	return KeyboardGrid{
+		Layout: layout,
+	}
+}
+
+func NewCustomGrid(rows ...int) KeyboardGrid {
+	return KeyboardGrid{
+		Layout: Custom,
+		Rows:   rows,
+	}
+}
+
+// Capacity returns how many buttons a custom grid holds, fixed layouts are unbounded and return -1.
+func (g KeyboardGrid) Capacity() int {
+	if g.Layout != Custom {
+		return -1
+	}
+	total := 0
+	for _, r := range g.Rows {
+		total += r
+	}
+	return total
+}
+
+// ArrangeKeyboard lays buttons out on the grid. A custom row spec has to cover all
+// buttons, the last rows may stay incomplete when there are fewer buttons.
+func ArrangeKeyboard[T any](grid KeyboardGrid, buttons ...T) ([][]T, error) {
+	if grid.Layout != Custom {
+		if grid.Layout.ButtonsPerRow() == 0 {
+			return nil, fmt.Errorf("%w: unknown layout %d", InvalidKeyboardError, grid.Layout)
+		}
+		return NewKeyboard(grid.Layout, buttons...), nil
+	}
+
+	for _, r := range grid.Rows {
+		if r <= 0 {
+			return nil, fmt.Errorf("%w: row size %d in custom layout", InvalidKeyboardError, r)
+		}
+	}
+	if capacity := grid.Capacity(); capacity < len(buttons) {
+		return nil, fmt.Errorf("%w: custom layout holds %d of %d buttons", InvalidKeyboardError, capacity, len(buttons))
+	}
+
+	var keyboard [][]T
+	i := 0
+	for _, r := range grid.Rows {
+		if i >= len(buttons) {
+			break
+		}
+		end := i + r
+		if end > len(buttons) {
+			end = len(buttons)
+		}
+		keyboard = append(keyboard, buttons[i:end])
+		i = end
+	}
+	return keyboard, nil
+}
+
+// NewInlineKeyboard arranges inline buttons on the grid and checks Telegram limits.
+func NewInlineKeyboard(grid KeyboardGrid, buttons ...*InlineButton) ([][]*InlineButton, error) {
+	keyboard, err := ArrangeKeyboard(grid, buttons...)
+	if err != nil {
+		return nil, err
+	}
+	return keyboard, ValidateInlineKeyboard(keyboard)
+}
+
+// NewReplyKeyboard arranges reply buttons on the grid and checks Telegram limits.
+func NewReplyKeyboard(grid KeyboardGrid, buttons ...*ReplyButton) ([][]*ReplyButton, error) {
+	keyboard, err := ArrangeKeyboard(grid, buttons...)
+	if err != nil {
+		return nil, err
+	}
+	return keyboard, ValidateReplyKeyboard(keyboard)
+}
+
+func ValidateInlineKeyboard(keyboard [][]*InlineButton) error {
+	err := validateKeyboardSize(keyboard, MaxInlineButtonsPerRow, MaxInlineButtons)
+	if err != nil {
+		return err
+	}
+	for i, row := range keyboard {
+		for j, button := range row {
+			if button.Kind == PayButton && (i != 0 || j != 0) {
+				return fmt.Errorf("%w: pay button has to be the first one", InvalidKeyboardError)
 			}
-			row := []T{buttons[i], buttons[i+1]}
-			keyboard = append(keyboard, row)
 		}
-	case ThreePerRow:
-		for i := 0; i < len(buttons); i += 3 {
-			if i+2 >= len(buttons) {
-				keyboard = append(keyboard, buttons[i:])
-				break
+	}
+	return nil
+}
+
+func ValidateReplyKeyboard(keyboard [][]*ReplyButton) error {
+	for _, row := range keyboard {
+		for _, button := range row {
+			if button.Kind == UsersReplyButton && button.UsersRequest == nil {
+				return fmt.Errorf("%w: users button %s has no request", InvalidKeyboardError, button.Text)
+			}
+			if button.Kind == ChatReplyButton && button.ChatRequest == nil {
+				return fmt.Errorf("%w: chat button %s has no request", InvalidKeyboardError, button.Text)
 			}
-			row := []T{buttons[i], buttons[i+1], buttons[i+2]}
-			keyboard = append(keyboard, row)
 		}
-	default:
-		for _, button := range buttons {
-			keyboard = append(keyboard, []T{button})
+	}
+	return validateKeyboardSize(keyboard, MaxReplyButtonsPerRow, MaxReplyButtons)
+}
+
+func validateKeyboardSize[T any](keyboard [][]T, maxPerRow int, maxTotal int) error {
+	total := 0
+	for i, row := range keyboard {
+		if len(row) > maxPerRow {
+			return fmt.Errorf("%w: row %d has %d buttons, max %d", InvalidKeyboardError, i, len(row), maxPerRow)
 		}
+		total += len(row)
 	}
-	return keyboard
+	if total > maxTotal {
+		return fmt.Errorf("%w: %d buttons, max %d", InvalidKeyboardError, total, maxTotal)
+	}
+	return nil
 }
 
+const (
+	DefaultNextButtonText     = "»"
+	DefaultPreviousButtonText = "«"
+)
+
+// MultiPageInlineKeyboard splits AllButtons into pages of PageSize buttons starting at Offset.
+// The processor renders the current page with navigation buttons and flips pages
+// by editing the message, the keyboard state travels with the navigation callbacks.
 type MultiPageInlineKeyboard struct {
 	AllButtons         []*InlineButton
 	Offset             int
+	PageSize           int
 	PageLayout         KeyboardLayout
+	PageRows           []int
 	CurrentButtons     [][]*InlineButton
 	NextButtonText     string
 	PreviousButtonText string
 }
+
+func NewMultiPageInlineKeyboard(layout KeyboardLayout, pageSize int, buttons ...*InlineButton) *MultiPageInlineKeyboard {
+	return &MultiPageInlineKeyboard{
+		AllButtons:         buttons,
+		PageSize:           pageSize,
+		PageLayout:         layout,
+		NextButtonText:     DefaultNextButtonText,
+		PreviousButtonText: DefaultPreviousButtonText,
+	}
+}
+
+func (k *MultiPageInlineKeyboard) WithNavigationText(previous string, next string) *MultiPageInlineKeyboard {
+	k.PreviousButtonText = previous
+	k.NextButtonText = next
+	return k
+}
+
+// WithPageRows switches the page layout to Custom with the given row spec.
+func (k *MultiPageInlineKeyboard) WithPageRows(rows ...int) *MultiPageInlineKeyboard {
+	k.PageLayout = Custom
+	k.PageRows = rows
+	return k
+}
+
+// Validate checks that a full page fits the page layout and Telegram limits.
+func (k *MultiPageInlineKeyboard) Validate() error {
+	grid := KeyboardGrid{Layout: k.PageLayout, Rows: k.PageRows}
+	pageSize := k.pageSize()
+	if pageSize > len(k.AllButtons) {
+		pageSize = len(k.AllButtons)
+	}
+	page, err := ArrangeKeyboard(grid, k.AllButtons[:pageSize]...)
+	if err != nil {
+		return err
+	}
+	err = ValidateInlineKeyboard(page)
+	if err != nil {
+		return err
+	}
+	if pageSize+2 > MaxInlineButtons {
+		return fmt.Errorf("%w: page of %d buttons leaves no room for navigation", InvalidKeyboardError, pageSize)
+	}
+	return nil
+}
+
+func (k *MultiPageInlineKeyboard) WithOffset(offset int) *MultiPageInlineKeyboard {
+	k.Offset = offset
+	return k
+}
+
+// Page returns a copy of the keyboard positioned at offset with CurrentButtons laid out.
+// Keyboards are often shared between users, so the receiver is left untouched.
+func (k *MultiPageInlineKeyboard) Page(offset int) *MultiPageInlineKeyboard {
+	page := *k
+	pageSize := k.pageSize()
+	if offset >= len(k.AllButtons) {
+		offset = (len(k.AllButtons) - 1) / pageSize * pageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	end := offset + pageSize
+	if end > len(k.AllButtons) {
+		end = len(k.AllButtons)
+	}
+	page.Offset = offset
+	current, err := ArrangeKeyboard(KeyboardGrid{Layout: k.PageLayout, Rows: k.PageRows}, k.AllButtons[offset:end]...)
+	if err != nil {
+		current = NewKeyboard(OnePerRow, k.AllButtons[offset:end]...)
+	}
+	page.CurrentButtons = current
+	return &page
+}
+
+func (k *MultiPageInlineKeyboard) HasPrevious() bool {
+	return k.Offset > 0
+}
+
+func (k *MultiPageInlineKeyboard) HasNext() bool {
+	return k.Offset+k.pageSize() < len(k.AllButtons)
+}
+
+func (k *MultiPageInlineKeyboard) PreviousOffset() int {
+	return k.Offset - k.pageSize()
+}
+
+func (k *MultiPageInlineKeyboard) NextOffset() int {
+	return k.Offset + k.pageSize()
+}
+
+func (k *MultiPageInlineKeyboard) pageSize() int {
+	if k.PageSize <= 0 {
+		if len(k.AllButtons) == 0 {
+			return 1
+		}
+		return len(k.AllButtons)
+	}
+	return k.PageSize
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	MediaGroupMinItems = 2
+	MediaGroupMaxItems = 10
+)
+
+var InvalidMediaGroupError = errors.New("invalid media group")
+
+type MediaType string
+
+const (
+	PhotoMedia     MediaType = "photo"
+	VideoMedia     MediaType = "video"
+	DocumentMedia  MediaType = "document"
+	AudioMedia     MediaType = "audio"
+	VoiceMedia     MediaType = "voice"
+	AnimationMedia MediaType = "animation"
+	StickerMedia   MediaType = "sticker"
+	VideoNoteMedia MediaType = "video_note"
+)
+
+// SupportsCaption reports whether Telegram accepts a caption for the media type.
+func (t MediaType) SupportsCaption() bool {
+	return t != StickerMedia && t != VideoNoteMedia
+}
+
+// InputFile describes the source of an outgoing file. Exactly one source should be set.
+// Reader sources are consumed on send, so they are not suitable for static initializers.
+type InputFile struct {
+	Name     string
+	MimeType string
+
+	Bytes  []byte
+	Reader io.Reader
+	Path   string
+	URL    string
+	FileID string
+}
+
+func NewFileBytes(name string, data []byte) *InputFile {
+	return &InputFile{
+		Name:  name,
+		Bytes: data,
+	}
+}
+
+func NewFileReader(name string, reader io.Reader) *InputFile {
+	return &InputFile{
+		Name:   name,
+		Reader: reader,
+	}
+}
+
+func NewFilePath(path string) *InputFile {
+	return &InputFile{
+		Path: path,
+	}
+}
+
+func NewFileURL(url string) *InputFile {
+	return &InputFile{
+		URL: url,
+	}
+}
+
+func NewFileID(fileID string) *InputFile {
+	return &InputFile{
+		FileID: fileID,
+	}
+}
+
+func (f *InputFile) WithName(name string) *InputFile {
+	f.Name = name
+	return f
+}
+
+func (f *InputFile) WithMimeType(mimeType string) *InputFile {
+	f.MimeType = mimeType
+	return f
+}
+
+// NeedsUpload reports whether the file content has to be sent as multipart data.
+func (f *InputFile) NeedsUpload() bool {
+	return f.FileID == "" && f.URL == ""
+}
+
+type Media struct {
+	Type MediaType
+	File *InputFile
+
+	// Caption is only used for media group items, single media take Message.Text.
+	Caption string
+}
+
+func NewMedia(mediaType MediaType, file *InputFile) *Media {
+	return &Media{
+		Type: mediaType,
+		File: file,
+	}
+}
+
+func (m *Media) WithCaption(caption string) *Media {
+	m.Caption = caption
+	return m
+}
+
+// MediaGroup is an album sent with a single sendMediaGroup call.
+type MediaGroup struct {
+	Media []*Media
+}
+
+func NewMediaGroup(media ...*Media) *MediaGroup {
+	return &MediaGroup{
+		Media: media,
+	}
+}
+
+// Validate checks the item count and type mixing rules of Telegram albums:
+// photos and videos may be mixed, documents and audio only with their own kind.
+func (g *MediaGroup) Validate() error {
+	if len(g.Media) < MediaGroupMinItems || len(g.Media) > MediaGroupMaxItems {
+		return fmt.Errorf("%w: %d items, expected %d-%d", InvalidMediaGroupError, len(g.Media), MediaGroupMinItems, MediaGroupMaxItems)
+	}
+	for _, media := range g.Media {
+		if media == nil || media.File == nil {
+			return fmt.Errorf("%w: item without file", InvalidMediaGroupError)
+		}
+	}
+	first := g.Media[0].Type
+	for _, media := range g.Media {
+		switch media.Type {
+		case PhotoMedia, VideoMedia:
+			if first != PhotoMedia && first != VideoMedia {
+				return fmt.Errorf("%w: %s can't be grouped with %s", InvalidMediaGroupError, media.Type, first)
+			}
+		case DocumentMedia, AudioMedia:
+			if media.Type != first {
+				return fmt.Errorf("%w: %s can't be grouped with %s", InvalidMediaGroupError, media.Type, first)
+			}
+		default:
+			return fmt.Errorf("%w: %s is not allowed in media groups", InvalidMediaGroupError, media.Type)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,62 @@
 package model
 
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
 type Message struct {
-	Text  string
+	Text       string
+	ParseMode  ParseMode
+	Media      *Media
+	MediaGroup *MediaGroup
+
+	ReplyKeyboard     [][]*ReplyButton
+	InlineKeyboard    [][]*InlineButton
+	MultiPageKeyboard *MultiPageInlineKeyboard
+
+	ReplyKeyboardOptions *ReplyKeyboardOptions
+	RemoveKeyboard       *RemoveKeyboardOptions
+	ForceReply           *ForceReplyOptions
+
+	ReplyToMessageID    int
+	DisableNotification bool
+	ProtectContent      bool
+	LinkPreview         *LinkPreviewOptions
+	EffectID            string
+
+	// Deprecated: use Media, set by WithPhoto.
 	Photo []byte
+	// Deprecated: use Media, set by WithVideo.
 	Video []byte
+}
 
-	ReplyKeyboard  [][]*ReplyButton
-	InlineKeyboard [][]*InlineButton
+type LinkPreviewOptions struct {
+	IsDisabled       bool   `json:"is_disabled,omitempty"`
+	URL              string `json:"url,omitempty"`
+	PreferSmallMedia bool   `json:"prefer_small_media,omitempty"`
+	PreferLargeMedia bool   `json:"prefer_large_media,omitempty"`
+	ShowAboveText    bool   `json:"show_above_text,omitempty"`
+}
+
+// ReplyKeyboardOptions tune how the client shows a reply keyboard. Without them the
+// keyboard is resized to fit its buttons.
+type ReplyKeyboardOptions struct {
+	Resize      bool
+	OneTime     bool
+	Persistent  bool
+	Placeholder string
+	Selective   bool
+}
+
+type RemoveKeyboardOptions struct {
+	Selective bool
+}
+
+type ForceReplyOptions struct {
+	Placeholder string
+	Selective   bool
 }
 
 type MessageOption func(*Message)
@@ -19,7 +69,87 @@ func NewMessage(opts ...MessageOption) *Message {
 	return msg
 }
 
-type Media struct {
+// Normalized returns the message with the deprecated Photo and Video fields mapped onto
+// Media, or onto a media group when both are set. Messages of static initializers are
+// shared between users, so a copy is returned rather than m modified.
+func (m *Message) Normalized() (*Message, error) {
+	if m.Photo == nil && m.Video == nil {
+		return m, nil
+	}
+	if m.Media != nil || m.MediaGroup != nil {
+		return nil, fmt.Errorf("%w: deprecated photo and video can't be combined with media", InvalidMediaGroupError)
+	}
+	var media []*Media
+	if m.Photo != nil {
+		media = append(media, NewMedia(PhotoMedia, NewFileBytes("photo.jpg", m.Photo)))
+	}
+	if m.Video != nil {
+		media = append(media, NewMedia(VideoMedia, NewFileBytes("video.mp4", m.Video)))
+	}
+	normalized := *m
+	normalized.Photo, normalized.Video = nil, nil
+	if len(media) == 1 {
+		normalized.Media = media[0]
+	} else {
+		normalized.MediaGroup = NewMediaGroup(media...)
+	}
+	return &normalized, nil
+}
+
+func (m *Message) Validate() error {
+	if m.MultiPageKeyboard != nil && (m.InlineKeyboard != nil || m.ReplyKeyboard != nil) {
+		return errors.New("multi page keyboard can't be combined with other keyboards")
+	}
+	if m.MultiPageKeyboard != nil {
+		err := m.MultiPageKeyboard.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	err := ValidateInlineKeyboard(m.InlineKeyboard)
+	if err != nil {
+		return err
+	}
+	err = ValidateReplyKeyboard(m.ReplyKeyboard)
+	if err != nil {
+		return err
+	}
+	err = m.validateReplyMarkupOptions()
+	if err != nil {
+		return err
+	}
+	if m.MediaGroup == nil {
+		return nil
+	}
+	if m.Media != nil {
+		return fmt.Errorf("%w: message can't carry both media and a media group", InvalidMediaGroupError)
+	}
+	if m.HasReplyMarkup() && m.Text == "" {
+		return fmt.Errorf("%w: keyboard requires message text", InvalidMediaGroupError)
+	}
+	return m.MediaGroup.Validate()
+}
+
+func (m *Message) validateReplyMarkupOptions() error {
+	if (m.RemoveKeyboard != nil || m.ForceReply != nil) && (m.ReplyKeyboard != nil || m.InlineKeyboard != nil || m.MultiPageKeyboard != nil) {
+		return fmt.Errorf("%w: keyboard removal and force reply can't be combined with keyboards", InvalidKeyboardError)
+	}
+	if m.RemoveKeyboard != nil && m.ForceReply != nil {
+		return fmt.Errorf("%w: keyboard removal can't be combined with force reply", InvalidKeyboardError)
+	}
+	if m.ReplyKeyboardOptions != nil && utf8.RuneCountInString(m.ReplyKeyboardOptions.Placeholder) > MaxInputFieldPlaceholderLength {
+		return fmt.Errorf("%w: placeholder exceeds %d characters", InvalidKeyboardError, MaxInputFieldPlaceholderLength)
+	}
+	if m.ForceReply != nil && utf8.RuneCountInString(m.ForceReply.Placeholder) > MaxInputFieldPlaceholderLength {
+		return fmt.Errorf("%w: placeholder exceeds %d characters", InvalidKeyboardError, MaxInputFieldPlaceholderLength)
+	}
+	return nil
+}
+
+// HasReplyMarkup reports whether the message carries any keyboard related markup.
+func (m *Message) HasReplyMarkup() bool {
+	return m.ReplyKeyboard != nil || m.InlineKeyboard != nil || m.MultiPageKeyboard != nil ||
+		m.RemoveKeyboard != nil || m.ForceReply != nil
 }
 
 func WithText(text string) MessageOption {
@@ -33,6 +163,30 @@ func (m *Message) WithText(text string) *Message {
 	return m
 }
 
+func WithParseMode(mode ParseMode) MessageOption {
+	return func(msg *Message) {
+		msg.ParseMode = mode
+	}
+}
+
+func (m *Message) WithParseMode(mode ParseMode) *Message {
+	m.ParseMode = mode
+	return m
+}
+
+func WithFormattedText(text *TextBuilder) MessageOption {
+	return func(msg *Message) {
+		msg.Text = text.String()
+		msg.ParseMode = text.ParseMode()
+	}
+}
+
+func (m *Message) WithFormattedText(text *TextBuilder) *Message {
+	m.Text = text.String()
+	m.ParseMode = text.ParseMode()
+	return m
+}
+
 func WithReplyKeyboard(keyboard [][]*ReplyButton) MessageOption {
 	return func(msg *Message) {
 		msg.ReplyKeyboard = keyboard
@@ -55,25 +209,215 @@ func (m *Message) WithInlineKeyboard(keyboard [][]*InlineButton) *Message {
 	return m
 }
 
-func WithPhoto(photo []byte) MessageOption {
+func WithMedia(media *Media) MessageOption {
 	return func(msg *Message) {
-		msg.Photo = photo
+		msg.Media = media
 	}
+}
 
+func (m *Message) WithMedia(media *Media) *Message {
+	m.Media = media
+	return m
 }
 
-func (m *Message) WithPhoto(photo []byte) *Message {
-	m.Photo = photo
+func WithMediaGroup(media ...*Media) MessageOption {
+	return func(msg *Message) {
+		msg.MediaGroup = NewMediaGroup(media...)
+	}
+}
+
+func (m *Message) WithMediaGroup(media ...*Media) *Message {
+	m.MediaGroup = NewMediaGroup(media...)
 	return m
 }
 
-func WithVideo(video []byte) MessageOption {
+// WithMultiPageInlineKeyboard attaches a paginated inline keyboard, it is rendered
+// into InlineKeyboard when the message is sent.
+func WithMultiPageInlineKeyboard(keyboard *MultiPageInlineKeyboard) MessageOption {
 	return func(msg *Message) {
-		msg.Video = video
+		msg.MultiPageKeyboard = keyboard
 	}
 }
 
+func (m *Message) WithMultiPageInlineKeyboard(keyboard *MultiPageInlineKeyboard) *Message {
+	m.MultiPageKeyboard = keyboard
+	return m
+}
+
+func WithPhoto(photo []byte) MessageOption {
+	return WithMedia(NewMedia(PhotoMedia, NewFileBytes("photo.jpg", photo)))
+}
+
+func (m *Message) WithPhoto(photo []byte) *Message {
+	return m.WithMedia(NewMedia(PhotoMedia, NewFileBytes("photo.jpg", photo)))
+}
+
+func WithVideo(video []byte) MessageOption {
+	return WithMedia(NewMedia(VideoMedia, NewFileBytes("video.mp4", video)))
+}
+
 func (m *Message) WithVideo(video []byte) *Message {
-	m.Video = video
+	return m.WithMedia(NewMedia(VideoMedia, NewFileBytes("video.mp4", video)))
+}
+
+func WithPhotoFile(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(PhotoMedia, file))
+}
+
+func (m *Message) WithPhotoFile(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(PhotoMedia, file))
+}
+
+func WithVideoFile(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(VideoMedia, file))
+}
+
+func (m *Message) WithVideoFile(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(VideoMedia, file))
+}
+
+func WithDocument(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(DocumentMedia, file))
+}
+
+func (m *Message) WithDocument(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(DocumentMedia, file))
+}
+
+func WithAudio(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(AudioMedia, file))
+}
+
+func (m *Message) WithAudio(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(AudioMedia, file))
+}
+
+func WithVoice(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(VoiceMedia, file))
+}
+
+func (m *Message) WithVoice(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(VoiceMedia, file))
+}
+
+func WithAnimation(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(AnimationMedia, file))
+}
+
+func (m *Message) WithAnimation(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(AnimationMedia, file))
+}
+
+func WithSticker(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(StickerMedia, file))
+}
+
+func (m *Message) WithSticker(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(StickerMedia, file))
+}
+
+func WithVideoNote(file *InputFile) MessageOption {
+	return WithMedia(NewMedia(VideoNoteMedia, file))
+}
+
+func (m *Message) WithVideoNote(file *InputFile) *Message {
+	return m.WithMedia(NewMedia(VideoNoteMedia, file))
+}
+
+func WithReplyTo(messageID int) MessageOption {
+	return func(msg *Message) {
+		msg.ReplyToMessageID = messageID
+	}
+}
+
+func (m *Message) WithReplyTo(messageID int) *Message {
+	m.ReplyToMessageID = messageID
+	return m
+}
+
+func WithDisableNotification(disabled bool) MessageOption {
+	return func(msg *Message) {
+		msg.DisableNotification = disabled
+	}
+}
+
+func (m *Message) WithDisableNotification(disabled bool) *Message {
+	m.DisableNotification = disabled
+	return m
+}
+
+func WithProtectContent(protect bool) MessageOption {
+	return func(msg *Message) {
+		msg.ProtectContent = protect
+	}
+}
+
+func (m *Message) WithProtectContent(protect bool) *Message {
+	m.ProtectContent = protect
+	return m
+}
+
+func WithLinkPreview(options *LinkPreviewOptions) MessageOption {
+	return func(msg *Message) {
+		msg.LinkPreview = options
+	}
+}
+
+func (m *Message) WithLinkPreview(options *LinkPreviewOptions) *Message {
+	m.LinkPreview = options
+	return m
+}
+
+func WithoutLinkPreview() MessageOption {
+	return WithLinkPreview(&LinkPreviewOptions{IsDisabled: true})
+}
+
+func (m *Message) WithoutLinkPreview() *Message {
+	return m.WithLinkPreview(&LinkPreviewOptions{IsDisabled: true})
+}
+
+func WithEffect(effectID string) MessageOption {
+	return func(msg *Message) {
+		msg.EffectID = effectID
+	}
+}
+
+func (m *Message) WithEffect(effectID string) *Message {
+	m.EffectID = effectID
+	return m
+}
+
+func WithReplyKeyboardOptions(options *ReplyKeyboardOptions) MessageOption {
+	return func(msg *Message) {
+		msg.ReplyKeyboardOptions = options
+	}
+}
+
+func (m *Message) WithReplyKeyboardOptions(options *ReplyKeyboardOptions) *Message {
+	m.ReplyKeyboardOptions = options
+	return m
+}
+
+// WithRemoveKeyboard hides the current reply keyboard, selective limits it to the
+// mentioned users and the author of the replied message.
+func WithRemoveKeyboard(selective bool) MessageOption {
+	return func(msg *Message) {
+		msg.RemoveKeyboard = &RemoveKeyboardOptions{Selective: selective}
+	}
+}
+
+func (m *Message) WithRemoveKeyboard(selective bool) *Message {
+	m.RemoveKeyboard = &RemoveKeyboardOptions{Selective: selective}
+	return m
+}
+
+func WithForceReply(options *ForceReplyOptions) MessageOption {
+	return func(msg *Message) {
+		msg.ForceReply = options
+	}
+}
+
+func (m *Message) WithForceReply(options *ForceReplyOptions) *Message {
+	m.ForceReply = options
 	return m
 }
@@ -1,10 +1,15 @@
 package model
 
 import (
+	"context"
 	"errors"
+	"fmt"
 )
 
-var UnrecognizedInputError = errors.New("unresolved input")
+var (
+	UnrecognizedInputError = errors.New("unresolved input")
+	ContextRequiredError   = errors.New("stage initializer needs the user context")
+)
 
 type PendingInput struct {
 	Body      string      `json:"body,omitempty"`
@@ -17,10 +22,37 @@ type Stage struct {
 
 	initializer   StageInitializer
 	defaultAction ResourceRef
+	inputRoutes   []*InputRoute
+	validation    *InputValidation
+	timers        []*StageTimer
+
+	commands        []*Command
+	commandPolicy   CommandPolicy
+	allowedCommands []ResourceRef
+
+	onEnter          StageHook
+	onExit           StageHook
+	onReenter        StageHook
+	reinitSuppressed bool
 }
 
+// StageHook runs on stage lifecycle events. It may change the user context,
+// returned messages are sent along with the messages of the update.
+type StageHook func(ctx *UserContext, stage ResourceRef) ([]*Message, error)
+
 type StageOption func(*Stage)
 
+// CommandPolicy decides which registry commands stay available in a stage,
+// commands of the stage itself are always available.
+type CommandPolicy int
+
+const (
+	AllCommandsAllowed CommandPolicy = iota
+	// ListedCommandsAllowed allows only the registry commands passed along with the policy.
+	ListedCommandsAllowed
+	NoCommandsAllowed
+)
+
 func NewStage(name string, opts ...StageOption) *Stage {
 	s := &Stage{
 		name: name,
@@ -64,6 +96,119 @@ func (s *Stage) WithDefaultAction(defaultAction ResourceRef) *Stage {
 	return s
 }
 
+func WithInputRoutes(routes ...*InputRoute) StageOption {
+	return func(stage *Stage) {
+		stage.inputRoutes = append(stage.inputRoutes, routes...)
+	}
+}
+
+func (s *Stage) WithInputRoutes(routes ...*InputRoute) *Stage {
+	s.inputRoutes = append(s.inputRoutes, routes...)
+	return s
+}
+
+// WithInputValidation validates custom input before it reaches the default action.
+func WithInputValidation(validation *InputValidation) StageOption {
+	return func(stage *Stage) {
+		stage.validation = validation
+	}
+}
+
+func (s *Stage) WithInputValidation(validation *InputValidation) *Stage {
+	s.validation = validation
+	return s
+}
+
+func WithTimers(timers ...*StageTimer) StageOption {
+	return func(stage *Stage) {
+		stage.timers = append(stage.timers, timers...)
+	}
+}
+
+func (s *Stage) WithTimers(timers ...*StageTimer) *Stage {
+	s.timers = append(s.timers, timers...)
+	return s
+}
+
+// WithCommands adds commands available only in the stage, they take precedence over
+// registry commands of the same name.
+func WithCommands(cmds ...*Command) StageOption {
+	return func(stage *Stage) {
+		stage.commands = append(stage.commands, cmds...)
+	}
+}
+
+func (s *Stage) WithCommands(cmds ...*Command) *Stage {
+	s.commands = append(s.commands, cmds...)
+	return s
+}
+
+// WithCommandPolicy restricts the registry commands available in the stage, the start
+// command is never blocked. Blocked commands are answered with a short notice, see
+// galaxia.WithCommandNotAllowedText.
+func WithCommandPolicy(policy CommandPolicy, allowed ...ResourceRef) StageOption {
+	return func(stage *Stage) {
+		stage.commandPolicy = policy
+		stage.allowedCommands = allowed
+	}
+}
+
+func (s *Stage) WithCommandPolicy(policy CommandPolicy, allowed ...ResourceRef) *Stage {
+	s.commandPolicy = policy
+	s.allowedCommands = allowed
+	return s
+}
+
+// WithOnEnter sets the hook run on transit into the stage, before its initializer.
+func WithOnEnter(hook StageHook) StageOption {
+	return func(stage *Stage) {
+		stage.onEnter = hook
+	}
+}
+
+func (s *Stage) WithOnEnter(hook StageHook) *Stage {
+	s.onEnter = hook
+	return s
+}
+
+// WithOnExit sets the hook run on transit out of the stage.
+func WithOnExit(hook StageHook) StageOption {
+	return func(stage *Stage) {
+		stage.onExit = hook
+	}
+}
+
+func (s *Stage) WithOnExit(hook StageHook) *Stage {
+	s.onExit = hook
+	return s
+}
+
+// WithOnReenter sets the hook run when the stage is re-initialized after an
+// action answered with messages without a transit.
+func WithOnReenter(hook StageHook) StageOption {
+	return func(stage *Stage) {
+		stage.onReenter = hook
+	}
+}
+
+func (s *Stage) WithOnReenter(hook StageHook) *Stage {
+	s.onReenter = hook
+	return s
+}
+
+// WithReinitSuppressed disables re-sending the initializer messages after an
+// action of the stage answers with messages.
+func WithReinitSuppressed(suppressed bool) StageOption {
+	return func(stage *Stage) {
+		stage.reinitSuppressed = suppressed
+	}
+}
+
+func (s *Stage) WithReinitSuppressed(suppressed bool) *Stage {
+	s.reinitSuppressed = suppressed
+	return s
+}
+
 func (s *Stage) SelfRef() ResourceRef {
 	return ResourceRef(s.name)
 }
@@ -79,19 +224,138 @@ func (s *Stage) DefaultActionRef() ResourceRef {
 	return s.defaultAction
 }
 
+func (s *Stage) InputRoutes() []*InputRoute {
+	return s.inputRoutes
+}
+
+func (s *Stage) Timers() []*StageTimer {
+	return s.timers
+}
+
+func (s *Stage) InputValidation() *InputValidation {
+	return s.validation
+}
+
+func (s *Stage) Commands() []*Command {
+	return s.commands
+}
+
+// Command returns the stage command named cmdRef or nil.
+func (s *Stage) Command(cmdRef ResourceRef) *Command {
+	for _, cmd := range s.commands {
+		if cmd.SelfRef() == cmdRef {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (s *Stage) CommandPolicy() (CommandPolicy, []ResourceRef) {
+	return s.commandPolicy, s.allowedCommands
+}
+
+// FindCommand returns the stage command answering to name or one of its aliases, or nil.
+func (s *Stage) FindCommand(name string, foldCase bool) *Command {
+	for _, cmd := range s.commands {
+		if cmd.Matches(name, foldCase) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// CommandAllowed reports whether the registry command cmdRef may run in the stage.
+func (s *Stage) CommandAllowed(cmdRef ResourceRef) bool {
+	switch s.commandPolicy {
+	case NoCommandsAllowed:
+		return false
+	case ListedCommandsAllowed:
+		for _, allowed := range s.allowedCommands {
+			if allowed == cmdRef {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// ScopesCommands reports whether the stage changes the set of commands available to the user.
+func (s *Stage) ScopesCommands() bool {
+	return len(s.commands) > 0 || s.commandPolicy != AllCommandsAllowed
+}
+
 func (s *Stage) CustomInputAllowed() bool {
 	return s.customInputAllowed
 }
 
+func (s *Stage) ReinitSuppressed() bool {
+	return s.reinitSuppressed
+}
+
+func (s *Stage) Enter(ctx *UserContext) ([]*Message, error) {
+	return runStageHook(s.onEnter, ctx, s.SelfRef())
+}
+
+func (s *Stage) Exit(ctx *UserContext) ([]*Message, error) {
+	return runStageHook(s.onExit, ctx, s.SelfRef())
+}
+
+func (s *Stage) Reenter(ctx *UserContext) ([]*Message, error) {
+	return runStageHook(s.onReenter, ctx, s.SelfRef())
+}
+
+func runStageHook(hook StageHook, ctx *UserContext, stage ResourceRef) ([]*Message, error) {
+	if hook == nil {
+		return nil, nil
+	}
+	return hook(ctx, stage)
+}
+
+// Initialize renders the stage messages from the user id only, FuncStageInitializer
+// fails with ContextRequiredError here, InitializeWithContext serves it.
 func (s *Stage) Initialize(userID int64, stage ResourceRef) ([]*Message, error) {
+	if s.initializer == nil {
+		return nil, nil
+	}
 	return s.initializer.Init(userID, stage)
 }
 
+// InitializeWithContext renders the stage messages for the user, initializers not
+// implementing ContextStageInitializer get only the user id.
+func (s *Stage) InitializeWithContext(ctx context.Context, userCtx *UserContext) ([]*Message, error) {
+	if s.initializer == nil {
+		return nil, nil
+	}
+	if initializer, ok := s.initializer.(ContextStageInitializer); ok {
+		return initializer.InitWithContext(ctx, userCtx, s.SelfRef())
+	}
+	return s.initializer.Init(userCtx.UserID, s.SelfRef())
+}
+
 // StageInitializer represents initializer interface
 type StageInitializer interface {
 	Init(userId int64, stage ResourceRef) ([]*Message, error)
 }
 
+// ContextStageInitializer is a StageInitializer able to render messages from the user context.
+type ContextStageInitializer interface {
+	StageInitializer
+	InitWithContext(ctx context.Context, userCtx *UserContext, stage ResourceRef) ([]*Message, error)
+}
+
+// FuncStageInitializer adapts a function to ContextStageInitializer.
+type FuncStageInitializer func(ctx context.Context, userCtx *UserContext, stage ResourceRef) ([]*Message, error)
+
+func (f FuncStageInitializer) Init(_ int64, stage ResourceRef) ([]*Message, error) {
+	return nil, fmt.Errorf("%w: stage %s", ContextRequiredError, stage)
+}
+
+func (f FuncStageInitializer) InitWithContext(ctx context.Context, userCtx *UserContext, stage ResourceRef) ([]*Message, error) {
+	return f(ctx, userCtx, stage)
+}
+
 type StaticStageInitializer struct {
 	Messages []*Message
 }
@@ -0,0 +1,58 @@
+package model
+
+import "time"
+
+// StageTimer fires when the user stays inactive in a stage for After. Timers of a
+// stage are armed on entering it and re-armed on every user update while the user
+// stays there, so each timer fires at most once per period of inactivity.
+type StageTimer struct {
+	After time.Duration
+
+	// Messages are sent without re-initializing the stage.
+	Messages []*Message
+	Transit  *Transit
+	// ActionRef, when set, takes precedence and is run with a synthetic tgbotapi update.
+	ActionRef ResourceRef
+}
+
+// NewReminder sends msgs after the given inactivity period.
+func NewReminder(after time.Duration, msgs ...*Message) *StageTimer {
+	return &StageTimer{
+		After:    after,
+		Messages: msgs,
+	}
+}
+
+// NewTimeout moves the user to the target stage after the given inactivity period.
+func NewTimeout(after time.Duration, targetStageRef ResourceRef, clean bool) *StageTimer {
+	return &StageTimer{
+		After: after,
+		Transit: &Transit{
+			TargetRef: targetStageRef,
+			Clean:     clean,
+		},
+	}
+}
+
+func NewTimerAction(after time.Duration, actionRef ResourceRef) *StageTimer {
+	return &StageTimer{
+		After:     after,
+		ActionRef: actionRef,
+	}
+}
+
+func (t *StageTimer) WithMessages(msgs ...*Message) *StageTimer {
+	t.Messages = append(t.Messages, msgs...)
+	return t
+}
+
+// Update builds the update of a timer without an action. The messages slice is
+// copied since the processor replaces its items with mapped messages.
+func (t *StageTimer) Update(userID int64) *UserUpdate {
+	return &UserUpdate{
+		UserID:   userID,
+		Messages: append([]*Message(nil), t.Messages...),
+		Transit:  t.Transit,
+		NoReinit: true,
+	}
+}
@@ -1,5 +1,7 @@
 package model
 
+import "errors"
+
 type UserUpdateOption func(*UserUpdate)
 
 type UserUpdate struct {
@@ -8,6 +10,11 @@ type UserUpdate struct {
 	Messages              []*Message
 	CallbackQueryResponse *CallbackQueryResponse
 	ToDeleteMessages      []int
+	Edits                 []*Edit
+	Call                  *FlowCall
+	Return                *FlowReturn
+	// NoReinit keeps the current stage from being initialized again after the messages.
+	NoReinit bool
 }
 
 func NewUserUpdate(userID int64, options ...UserUpdateOption) *UserUpdate {
@@ -22,6 +29,8 @@ func NewUserUpdate(userID int64, options ...UserUpdateOption) *UserUpdate {
 	return update
 }
 
+// WithTransit moves the user to the target stage, the current stage is pushed to the
+// stage history so it can be returned to with WithTransitPop.
 func WithTransit(targetStageRef ResourceRef, clean bool) UserUpdateOption {
 	return func(response *UserUpdate) {
 		response.Transit = &Transit{
@@ -31,6 +40,34 @@ func WithTransit(targetStageRef ResourceRef, clean bool) UserUpdateOption {
 	}
 }
 
+// WithTransitReplace moves the user to the target stage without recording the current one.
+func WithTransitReplace(targetStageRef ResourceRef, clean bool) UserUpdateOption {
+	return func(response *UserUpdate) {
+		response.Transit = &Transit{
+			TargetRef: targetStageRef,
+			Clean:     clean,
+			Mode:      TransitReplace,
+		}
+	}
+}
+
+// WithTransitPop returns the user to the previous stage of the history. With an
+// empty history the current stage is entered again.
+func WithTransitPop(clean bool) UserUpdateOption {
+	return func(response *UserUpdate) {
+		response.Transit = &Transit{
+			Clean: clean,
+			Mode:  TransitPop,
+		}
+	}
+}
+
+func WithoutReinit() UserUpdateOption {
+	return func(response *UserUpdate) {
+		response.NoReinit = true
+	}
+}
+
 func WithMessages(msg ...*Message) UserUpdateOption {
 	return func(response *UserUpdate) {
 		response.Messages = append(response.Messages, msg...)
@@ -49,12 +86,78 @@ func WithToDeleteMessages(toDeleteMessages []int) UserUpdateOption {
 	}
 }
 
+func WithEditText(messageID int, msg *Message) UserUpdateOption {
+	return withEdit(EditText, messageID, msg)
+}
+
+func WithEditCaption(messageID int, msg *Message) UserUpdateOption {
+	return withEdit(EditCaption, messageID, msg)
+}
+
+func WithEditMedia(messageID int, msg *Message) UserUpdateOption {
+	return withEdit(EditMedia, messageID, msg)
+}
+
+func WithEditReplyMarkup(messageID int, keyboard [][]*InlineButton) UserUpdateOption {
+	return withEdit(EditReplyMarkup, messageID, NewMessage(WithInlineKeyboard(keyboard)))
+}
+
+func WithEditMultiPageKeyboard(messageID int, keyboard *MultiPageInlineKeyboard) UserUpdateOption {
+	return withEdit(EditReplyMarkup, messageID, NewMessage(WithMultiPageInlineKeyboard(keyboard)))
+}
+
+func withEdit(kind EditKind, messageID int, msg *Message) UserUpdateOption {
+	return func(response *UserUpdate) {
+		response.Edits = append(response.Edits, &Edit{
+			Kind:      kind,
+			MessageID: messageID,
+			Message:   msg,
+		})
+	}
+}
+
 type CallbackQueryResponse struct {
 	Text            string
 	CallbackQueryID string
 }
 
+type TransitMode int
+
+const (
+	TransitPush TransitMode = iota
+	TransitReplace
+	TransitPop
+)
+
 type Transit struct {
 	Clean     bool
 	TargetRef ResourceRef
+	Mode      TransitMode
+}
+
+type EditKind int
+
+const (
+	EditText EditKind = iota
+	EditCaption
+	EditMedia
+	EditReplyMarkup
+)
+
+// Edit modifies an already sent message. A zero MessageID targets the message
+// the handled callback button belongs to. Only inline keyboards can be attached.
+type Edit struct {
+	Kind      EditKind
+	MessageID int
+	Message   *Message
+}
+
+func (e *Edit) Validate() error {
+	if e.Kind == EditMedia && (e.Message == nil || e.Message.Media == nil) {
+		return errors.New("media edit without media")
+	}
+	if e.Message == nil {
+		return nil
+	}
+	return e.Message.Validate()
 }
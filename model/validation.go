@@ -0,0 +1,164 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultInputErrorText answers rejected input of validations without an error text.
+const DefaultInputErrorText = "That doesn't look right, please try again."
+
+var (
+	InvalidInputError = errors.New("invalid input")
+	phoneRe           = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+	phoneSeparators   = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+)
+
+// InputValidator checks custom stage input and returns its normalized value.
+// Values have to be JSON compatible since they are stored into UserContext.Misc.
+type InputValidator func(input string) (interface{}, error)
+
+func RegexValidator(re *regexp.Regexp) InputValidator {
+	return func(input string) (interface{}, error) {
+		if !re.MatchString(input) {
+			return nil, fmt.Errorf("%w: %q doesn't match the expected format", InvalidInputError, input)
+		}
+		return input, nil
+	}
+}
+
+// LengthValidator checks the character count of the input, a zero max means no upper bound.
+func LengthValidator(min, max int) InputValidator {
+	return func(input string) (interface{}, error) {
+		n := utf8.RuneCountInString(input)
+		if n < min {
+			return nil, fmt.Errorf("%w: expected at least %d characters", InvalidInputError, min)
+		}
+		if max > 0 && n > max {
+			return nil, fmt.Errorf("%w: expected at most %d characters", InvalidInputError, max)
+		}
+		return input, nil
+	}
+}
+
+// NumberRangeValidator parses a number, accepting a decimal comma, and stores it as float64.
+func NumberRangeValidator(min, max float64) InputValidator {
+	return func(input string) (interface{}, error) {
+		n, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(input), ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a number", InvalidInputError, input)
+		}
+		if n < min || n > max {
+			return nil, fmt.Errorf("%w: expected a number between %v and %v", InvalidInputError, min, max)
+		}
+		return n, nil
+	}
+}
+
+// DateValidator parses the input with layout and stores the date as an RFC 3339 string.
+func DateValidator(layout string) InputValidator {
+	return func(input string) (interface{}, error) {
+		t, err := time.Parse(layout, strings.TrimSpace(input))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a date like %s", InvalidInputError, input, layout)
+		}
+		return t.Format(time.RFC3339), nil
+	}
+}
+
+func EmailValidator() InputValidator {
+	return func(input string) (interface{}, error) {
+		return ParseEmail(input)
+	}
+}
+
+func PhoneValidator() InputValidator {
+	return func(input string) (interface{}, error) {
+		return ParsePhone(input)
+	}
+}
+
+// ParseEmail accepts a bare email address and lowercases it.
+func ParseEmail(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	addr, err := mail.ParseAddress(input)
+	if err != nil || addr.Address != input {
+		return "", fmt.Errorf("%w: %q is not an email address", InvalidInputError, input)
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// ParsePhone strips separators from a phone number and prefixes it with a plus.
+func ParsePhone(input string) (string, error) {
+	phone := phoneSeparators.Replace(strings.TrimSpace(input))
+	if !phoneRe.MatchString(phone) {
+		return "", fmt.Errorf("%w: %q is not a phone number", InvalidInputError, input)
+	}
+	if !strings.HasPrefix(phone, "+") {
+		phone = "+" + phone
+	}
+	return phone, nil
+}
+
+// InputValidation guards the default action of a custom input stage. Rejected input
+// is answered with the error text, DefaultInputErrorText without one, and the stage
+// prompt is repeated, after MaxFailures rejections in a row the escalation action
+// runs instead.
+type InputValidation struct {
+	Validators    []InputValidator
+	MiscKey       string
+	ErrorText     string
+	MaxFailures   int
+	EscalationRef ResourceRef
+}
+
+// NewInputValidation stores the value returned by the last validator into Misc under miscKey.
+func NewInputValidation(miscKey string, validators ...InputValidator) *InputValidation {
+	return &InputValidation{
+		Validators: validators,
+		MiscKey:    miscKey,
+	}
+}
+
+// WithErrorText replaces DefaultInputErrorText shown to the user, validator errors
+// are only logged.
+func (v *InputValidation) WithErrorText(text string) *InputValidation {
+	v.ErrorText = text
+	return v
+}
+
+func (v *InputValidation) WithEscalation(maxFailures int, actionRef ResourceRef) *InputValidation {
+	v.MaxFailures = maxFailures
+	v.EscalationRef = actionRef
+	return v
+}
+
+func (v *InputValidation) Validate(input string) (interface{}, error) {
+	var value interface{} = input
+	for _, validator := range v.Validators {
+		var err error
+		value, err = validator(input)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func (v *InputValidation) ErrorMessage() string {
+	if v.ErrorText != "" {
+		return v.ErrorText
+	}
+	return DefaultInputErrorText
+}
+
+// Escalates reports whether failures rejections in a row hand the input over to the escalation action.
+func (v *InputValidation) Escalates(failures int) bool {
+	return v.MaxFailures > 0 && failures >= v.MaxFailures && !v.EscalationRef.Empty()
+}
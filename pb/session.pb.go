@@ -69,18 +69,364 @@ func (CallbackBehaviour) EnumDescriptor() ([]byte, []int) {
 	return file_session_proto_rawDescGZIP(), []int{0}
 }
 
+type SwitchInlineQueryChosenChat struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Query             string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	AllowUserChats    bool                   `protobuf:"varint,2,opt,name=allow_user_chats,json=allowUserChats,proto3" json:"allow_user_chats,omitempty"`
+	AllowBotChats     bool                   `protobuf:"varint,3,opt,name=allow_bot_chats,json=allowBotChats,proto3" json:"allow_bot_chats,omitempty"`
+	AllowGroupChats   bool                   `protobuf:"varint,4,opt,name=allow_group_chats,json=allowGroupChats,proto3" json:"allow_group_chats,omitempty"`
+	AllowChannelChats bool                   `protobuf:"varint,5,opt,name=allow_channel_chats,json=allowChannelChats,proto3" json:"allow_channel_chats,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *SwitchInlineQueryChosenChat) Reset() {
+	*x = SwitchInlineQueryChosenChat{}
+	mi := &file_session_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SwitchInlineQueryChosenChat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SwitchInlineQueryChosenChat) ProtoMessage() {}
+
+func (x *SwitchInlineQueryChosenChat) ProtoReflect() protoreflect.Message {
+	mi := &file_session_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SwitchInlineQueryChosenChat.ProtoReflect.Descriptor instead.
+func (*SwitchInlineQueryChosenChat) Descriptor() ([]byte, []int) {
+	return file_session_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SwitchInlineQueryChosenChat) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SwitchInlineQueryChosenChat) GetAllowUserChats() bool {
+	if x != nil {
+		return x.AllowUserChats
+	}
+	return false
+}
+
+func (x *SwitchInlineQueryChosenChat) GetAllowBotChats() bool {
+	if x != nil {
+		return x.AllowBotChats
+	}
+	return false
+}
+
+func (x *SwitchInlineQueryChosenChat) GetAllowGroupChats() bool {
+	if x != nil {
+		return x.AllowGroupChats
+	}
+	return false
+}
+
+func (x *SwitchInlineQueryChosenChat) GetAllowChannelChats() bool {
+	if x != nil {
+		return x.AllowChannelChats
+	}
+	return false
+}
+
+type LoginUrl struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Url                string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	ForwardText        string                 `protobuf:"bytes,2,opt,name=forward_text,json=forwardText,proto3" json:"forward_text,omitempty"`
+	BotUsername        string                 `protobuf:"bytes,3,opt,name=bot_username,json=botUsername,proto3" json:"bot_username,omitempty"`
+	RequestWriteAccess bool                   `protobuf:"varint,4,opt,name=request_write_access,json=requestWriteAccess,proto3" json:"request_write_access,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *LoginUrl) Reset() {
+	*x = LoginUrl{}
+	mi := &file_session_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoginUrl) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoginUrl) ProtoMessage() {}
+
+func (x *LoginUrl) ProtoReflect() protoreflect.Message {
+	mi := &file_session_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoginUrl.ProtoReflect.Descriptor instead.
+func (*LoginUrl) Descriptor() ([]byte, []int) {
+	return file_session_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LoginUrl) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *LoginUrl) GetForwardText() string {
+	if x != nil {
+		return x.ForwardText
+	}
+	return ""
+}
+
+func (x *LoginUrl) GetBotUsername() string {
+	if x != nil {
+		return x.BotUsername
+	}
+	return ""
+}
+
+func (x *LoginUrl) GetRequestWriteAccess() bool {
+	if x != nil {
+		return x.RequestWriteAccess
+	}
+	return false
+}
+
+type InlineButton struct {
+	state         protoimpl.MessageState       `protogen:"open.v1"`
+	Text          string                       `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	UserData      string                       `protobuf:"bytes,2,opt,name=user_data,json=userData,proto3" json:"user_data,omitempty"`
+	HandlerRef    string                       `protobuf:"bytes,3,opt,name=handler_ref,json=handlerRef,proto3" json:"handler_ref,omitempty"`
+	Behaviour     CallbackBehaviour            `protobuf:"varint,4,opt,name=behaviour,proto3,enum=sessionpb.CallbackBehaviour" json:"behaviour,omitempty"`
+	Kind          int64                        `protobuf:"varint,5,opt,name=kind,proto3" json:"kind,omitempty"`
+	Url           string                       `protobuf:"bytes,6,opt,name=url,proto3" json:"url,omitempty"`
+	Query         string                       `protobuf:"bytes,7,opt,name=query,proto3" json:"query,omitempty"`
+	ChosenChat    *SwitchInlineQueryChosenChat `protobuf:"bytes,8,opt,name=chosen_chat,json=chosenChat,proto3" json:"chosen_chat,omitempty"`
+	LoginUrl      *LoginUrl                    `protobuf:"bytes,9,opt,name=login_url,json=loginUrl,proto3" json:"login_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InlineButton) Reset() {
+	*x = InlineButton{}
+	mi := &file_session_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InlineButton) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InlineButton) ProtoMessage() {}
+
+func (x *InlineButton) ProtoReflect() protoreflect.Message {
+	mi := &file_session_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InlineButton.ProtoReflect.Descriptor instead.
+func (*InlineButton) Descriptor() ([]byte, []int) {
+	return file_session_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *InlineButton) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *InlineButton) GetUserData() string {
+	if x != nil {
+		return x.UserData
+	}
+	return ""
+}
+
+func (x *InlineButton) GetHandlerRef() string {
+	if x != nil {
+		return x.HandlerRef
+	}
+	return ""
+}
+
+func (x *InlineButton) GetBehaviour() CallbackBehaviour {
+	if x != nil {
+		return x.Behaviour
+	}
+	return CallbackBehaviour_CALLBACK_BEHAVIOUR_RETAIN
+}
+
+func (x *InlineButton) GetKind() int64 {
+	if x != nil {
+		return x.Kind
+	}
+	return 0
+}
+
+func (x *InlineButton) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *InlineButton) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *InlineButton) GetChosenChat() *SwitchInlineQueryChosenChat {
+	if x != nil {
+		return x.ChosenChat
+	}
+	return nil
+}
+
+func (x *InlineButton) GetLoginUrl() *LoginUrl {
+	if x != nil {
+		return x.LoginUrl
+	}
+	return nil
+}
+
+type MultiPageKeyboard struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Buttons            []*InlineButton        `protobuf:"bytes,1,rep,name=buttons,proto3" json:"buttons,omitempty"`
+	Offset             int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	PageSize           int64                  `protobuf:"varint,3,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	Layout             int64                  `protobuf:"varint,4,opt,name=layout,proto3" json:"layout,omitempty"`
+	NextButtonText     string                 `protobuf:"bytes,5,opt,name=next_button_text,json=nextButtonText,proto3" json:"next_button_text,omitempty"`
+	PreviousButtonText string                 `protobuf:"bytes,6,opt,name=previous_button_text,json=previousButtonText,proto3" json:"previous_button_text,omitempty"`
+	PageRows           []int64                `protobuf:"varint,7,rep,packed,name=page_rows,json=pageRows,proto3" json:"page_rows,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *MultiPageKeyboard) Reset() {
+	*x = MultiPageKeyboard{}
+	mi := &file_session_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MultiPageKeyboard) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MultiPageKeyboard) ProtoMessage() {}
+
+func (x *MultiPageKeyboard) ProtoReflect() protoreflect.Message {
+	mi := &file_session_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MultiPageKeyboard.ProtoReflect.Descriptor instead.
+func (*MultiPageKeyboard) Descriptor() ([]byte, []int) {
+	return file_session_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MultiPageKeyboard) GetButtons() []*InlineButton {
+	if x != nil {
+		return x.Buttons
+	}
+	return nil
+}
+
+func (x *MultiPageKeyboard) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *MultiPageKeyboard) GetPageSize() int64 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *MultiPageKeyboard) GetLayout() int64 {
+	if x != nil {
+		return x.Layout
+	}
+	return 0
+}
+
+func (x *MultiPageKeyboard) GetNextButtonText() string {
+	if x != nil {
+		return x.NextButtonText
+	}
+	return ""
+}
+
+func (x *MultiPageKeyboard) GetPreviousButtonText() string {
+	if x != nil {
+		return x.PreviousButtonText
+	}
+	return ""
+}
+
+func (x *MultiPageKeyboard) GetPageRows() []int64 {
+	if x != nil {
+		return x.PageRows
+	}
+	return nil
+}
+
 type PendingCallback struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	UserData      string                 `protobuf:"bytes,1,opt,name=user_data,json=userData,proto3" json:"user_data,omitempty"`
 	HandlerRef    string                 `protobuf:"bytes,2,opt,name=handler_ref,json=handlerRef,proto3" json:"handler_ref,omitempty"`
 	Behaviour     CallbackBehaviour      `protobuf:"varint,3,opt,name=behaviour,proto3,enum=sessionpb.CallbackBehaviour" json:"behaviour,omitempty"`
+	MessageId     int64                  `protobuf:"varint,4,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	Page          *MultiPageKeyboard     `protobuf:"bytes,5,opt,name=page,proto3" json:"page,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PendingCallback) Reset() {
 	*x = PendingCallback{}
-	mi := &file_session_proto_msgTypes[0]
+	mi := &file_session_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -92,7 +438,7 @@ func (x *PendingCallback) String() string {
 func (*PendingCallback) ProtoMessage() {}
 
 func (x *PendingCallback) ProtoReflect() protoreflect.Message {
-	mi := &file_session_proto_msgTypes[0]
+	mi := &file_session_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -105,7 +451,7 @@ func (x *PendingCallback) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PendingCallback.ProtoReflect.Descriptor instead.
 func (*PendingCallback) Descriptor() ([]byte, []int) {
-	return file_session_proto_rawDescGZIP(), []int{0}
+	return file_session_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *PendingCallback) GetUserData() string {
@@ -129,6 +475,20 @@ func (x *PendingCallback) GetBehaviour() CallbackBehaviour {
 	return CallbackBehaviour_CALLBACK_BEHAVIOUR_RETAIN
 }
 
+func (x *PendingCallback) GetMessageId() int64 {
+	if x != nil {
+		return x.MessageId
+	}
+	return 0
+}
+
+func (x *PendingCallback) GetPage() *MultiPageKeyboard {
+	if x != nil {
+		return x.Page
+	}
+	return nil
+}
+
 type UserContext struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	UserId        int64                  `protobuf:"varint,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
@@ -143,7 +503,7 @@ type UserContext struct {
 
 func (x *UserContext) Reset() {
 	*x = UserContext{}
-	mi := &file_session_proto_msgTypes[1]
+	mi := &file_session_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -155,7 +515,7 @@ func (x *UserContext) String() string {
 func (*UserContext) ProtoMessage() {}
 
 func (x *UserContext) ProtoReflect() protoreflect.Message {
-	mi := &file_session_proto_msgTypes[1]
+	mi := &file_session_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -168,7 +528,7 @@ func (x *UserContext) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UserContext.ProtoReflect.Descriptor instead.
 func (*UserContext) Descriptor() ([]byte, []int) {
-	return file_session_proto_rawDescGZIP(), []int{1}
+	return file_session_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *UserContext) GetUserId() int64 {
@@ -213,6 +573,82 @@ func (x *UserContext) GetMisc() *structpb.Struct {
 	return nil
 }
 
+type FlowFrame struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ReturnStage     string                 `protobuf:"bytes,1,opt,name=return_stage,json=returnStage,proto3" json:"return_stage,omitempty"`
+	ContinuationRef string                 `protobuf:"bytes,2,opt,name=continuation_ref,json=continuationRef,proto3" json:"continuation_ref,omitempty"`
+	Params          *structpb.Struct       `protobuf:"bytes,3,opt,name=params,proto3" json:"params,omitempty"`
+	StageHistory    []string               `protobuf:"bytes,4,rep,name=stage_history,json=stageHistory,proto3" json:"stage_history,omitempty"`
+	PendingInputs   map[string]string      `protobuf:"bytes,5,rep,name=pending_inputs,json=pendingInputs,proto3" json:"pending_inputs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *FlowFrame) Reset() {
+	*x = FlowFrame{}
+	mi := &file_session_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FlowFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FlowFrame) ProtoMessage() {}
+
+func (x *FlowFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_session_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FlowFrame.ProtoReflect.Descriptor instead.
+func (*FlowFrame) Descriptor() ([]byte, []int) {
+	return file_session_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FlowFrame) GetReturnStage() string {
+	if x != nil {
+		return x.ReturnStage
+	}
+	return ""
+}
+
+func (x *FlowFrame) GetContinuationRef() string {
+	if x != nil {
+		return x.ContinuationRef
+	}
+	return ""
+}
+
+func (x *FlowFrame) GetParams() *structpb.Struct {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *FlowFrame) GetStageHistory() []string {
+	if x != nil {
+		return x.StageHistory
+	}
+	return nil
+}
+
+func (x *FlowFrame) GetPendingInputs() map[string]string {
+	if x != nil {
+		return x.PendingInputs
+	}
+	return nil
+}
+
 type Session struct {
 	state            protoimpl.MessageState      `protogen:"open.v1"`
 	ExpireTime       *timestamppb.Timestamp      `protobuf:"bytes,1,opt,name=expire_time,json=expireTime,proto3" json:"expire_time,omitempty"`
@@ -223,13 +659,17 @@ type Session struct {
 	PendingCallbacks map[string]*PendingCallback `protobuf:"bytes,6,rep,name=pending_callbacks,json=pendingCallbacks,proto3" json:"pending_callbacks,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	PendingInputs    map[string]string           `protobuf:"bytes,7,rep,name=pending_inputs,json=pendingInputs,proto3" json:"pending_inputs,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	StageMessages    []int64                     `protobuf:"varint,8,rep,packed,name=stage_messages,json=stageMessages,proto3" json:"stage_messages,omitempty"`
+	StageHistory     []string                    `protobuf:"bytes,9,rep,name=stage_history,json=stageHistory,proto3" json:"stage_history,omitempty"`
+	CallStack        []*FlowFrame                `protobuf:"bytes,10,rep,name=call_stack,json=callStack,proto3" json:"call_stack,omitempty"`
+	InputFailures    int64                       `protobuf:"varint,11,opt,name=input_failures,json=inputFailures,proto3" json:"input_failures,omitempty"`
+	CommandMenu      string                      `protobuf:"bytes,12,opt,name=command_menu,json=commandMenu,proto3" json:"command_menu,omitempty"`
 	unknownFields    protoimpl.UnknownFields
 	sizeCache        protoimpl.SizeCache
 }
 
 func (x *Session) Reset() {
 	*x = Session{}
-	mi := &file_session_proto_msgTypes[2]
+	mi := &file_session_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -241,7 +681,7 @@ func (x *Session) String() string {
 func (*Session) ProtoMessage() {}
 
 func (x *Session) ProtoReflect() protoreflect.Message {
-	mi := &file_session_proto_msgTypes[2]
+	mi := &file_session_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -254,7 +694,7 @@ func (x *Session) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Session.ProtoReflect.Descriptor instead.
 func (*Session) Descriptor() ([]byte, []int) {
-	return file_session_proto_rawDescGZIP(), []int{2}
+	return file_session_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Session) GetExpireTime() *timestamppb.Timestamp {
@@ -313,23 +753,94 @@ func (x *Session) GetStageMessages() []int64 {
 	return nil
 }
 
+func (x *Session) GetStageHistory() []string {
+	if x != nil {
+		return x.StageHistory
+	}
+	return nil
+}
+
+func (x *Session) GetCallStack() []*FlowFrame {
+	if x != nil {
+		return x.CallStack
+	}
+	return nil
+}
+
+func (x *Session) GetInputFailures() int64 {
+	if x != nil {
+		return x.InputFailures
+	}
+	return 0
+}
+
+func (x *Session) GetCommandMenu() string {
+	if x != nil {
+		return x.CommandMenu
+	}
+	return ""
+}
+
 var File_session_proto protoreflect.FileDescriptor
 
 const file_session_proto_rawDesc = "" +
 	"\n" +
-	"\rsession.proto\x12\tsessionpb\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1cgoogle/protobuf/struct.proto\"\x8b\x01\n" +
+	"\rsession.proto\x12\tsessionpb\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1cgoogle/protobuf/struct.proto\"\xe1\x01\n" +
+	"\x1bSwitchInlineQueryChosenChat\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x12(\n" +
+	"\x10allow_user_chats\x18\x02 \x01(\bR\x0eallowUserChats\x12&\n" +
+	"\x0fallow_bot_chats\x18\x03 \x01(\bR\rallowBotChats\x12*\n" +
+	"\x11allow_group_chats\x18\x04 \x01(\bR\x0fallowGroupChats\x12.\n" +
+	"\x13allow_channel_chats\x18\x05 \x01(\bR\x11allowChannelChats\"\x94\x01\n" +
+	"\bLoginUrl\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12!\n" +
+	"\fforward_text\x18\x02 \x01(\tR\vforwardText\x12!\n" +
+	"\fbot_username\x18\x03 \x01(\tR\vbotUsername\x120\n" +
+	"\x14request_write_access\x18\x04 \x01(\bR\x12requestWriteAccess\"\xd3\x02\n" +
+	"\fInlineButton\x12\x12\n" +
+	"\x04text\x18\x01 \x01(\tR\x04text\x12\x1b\n" +
+	"\tuser_data\x18\x02 \x01(\tR\buserData\x12\x1f\n" +
+	"\vhandler_ref\x18\x03 \x01(\tR\n" +
+	"handlerRef\x12:\n" +
+	"\tbehaviour\x18\x04 \x01(\x0e2\x1c.sessionpb.CallbackBehaviourR\tbehaviour\x12\x12\n" +
+	"\x04kind\x18\x05 \x01(\x03R\x04kind\x12\x10\n" +
+	"\x03url\x18\x06 \x01(\tR\x03url\x12\x14\n" +
+	"\x05query\x18\a \x01(\tR\x05query\x12G\n" +
+	"\vchosen_chat\x18\b \x01(\v2&.sessionpb.SwitchInlineQueryChosenChatR\n" +
+	"chosenChat\x120\n" +
+	"\tlogin_url\x18\t \x01(\v2\x13.sessionpb.LoginUrlR\bloginUrl\"\x8c\x02\n" +
+	"\x11MultiPageKeyboard\x121\n" +
+	"\abuttons\x18\x01 \x03(\v2\x17.sessionpb.InlineButtonR\abuttons\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x1b\n" +
+	"\tpage_size\x18\x03 \x01(\x03R\bpageSize\x12\x16\n" +
+	"\x06layout\x18\x04 \x01(\x03R\x06layout\x12(\n" +
+	"\x10next_button_text\x18\x05 \x01(\tR\x0enextButtonText\x120\n" +
+	"\x14previous_button_text\x18\x06 \x01(\tR\x12previousButtonText\x12\x1b\n" +
+	"\tpage_rows\x18\a \x03(\x03R\bpageRows\"\xdc\x01\n" +
 	"\x0fPendingCallback\x12\x1b\n" +
 	"\tuser_data\x18\x01 \x01(\tR\buserData\x12\x1f\n" +
 	"\vhandler_ref\x18\x02 \x01(\tR\n" +
 	"handlerRef\x12:\n" +
-	"\tbehaviour\x18\x03 \x01(\x0e2\x1c.sessionpb.CallbackBehaviourR\tbehaviour\"\xb4\x01\n" +
+	"\tbehaviour\x18\x03 \x01(\x0e2\x1c.sessionpb.CallbackBehaviourR\tbehaviour\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x04 \x01(\x03R\tmessageId\x120\n" +
+	"\x04page\x18\x05 \x01(\v2\x1c.sessionpb.MultiPageKeyboardR\x04page\"\xb4\x01\n" +
 	"\vUserContext\x12\x17\n" +
 	"\auser_id\x18\x01 \x01(\x03R\x06userId\x12\x12\n" +
 	"\x04lang\x18\x02 \x01(\tR\x04lang\x12\x12\n" +
 	"\x04name\x18\x03 \x01(\tR\x04name\x12\x1b\n" +
 	"\tlast_name\x18\x04 \x01(\tR\blastName\x12\x1a\n" +
 	"\busername\x18\x05 \x01(\tR\busername\x12+\n" +
-	"\x04misc\x18\x06 \x01(\v2\x17.google.protobuf.StructR\x04misc\"\xb7\x04\n" +
+	"\x04misc\x18\x06 \x01(\v2\x17.google.protobuf.StructR\x04misc\"\xc1\x02\n" +
+	"\tFlowFrame\x12!\n" +
+	"\freturn_stage\x18\x01 \x01(\tR\vreturnStage\x12)\n" +
+	"\x10continuation_ref\x18\x02 \x01(\tR\x0fcontinuationRef\x12/\n" +
+	"\x06params\x18\x03 \x01(\v2\x17.google.protobuf.StructR\x06params\x12#\n" +
+	"\rstage_history\x18\x04 \x03(\tR\fstageHistory\x12N\n" +
+	"\x0epending_inputs\x18\x05 \x03(\v2'.sessionpb.FlowFrame.PendingInputsEntryR\rpendingInputs\x1a@\n" +
+	"\x12PendingInputsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xdb\x05\n" +
 	"\aSession\x12;\n" +
 	"\vexpire_time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
 	"expireTime\x12\x10\n" +
@@ -339,7 +850,13 @@ const file_session_proto_rawDesc = "" +
 	"\acontext\x18\x05 \x01(\v2\x16.sessionpb.UserContextR\acontext\x12U\n" +
 	"\x11pending_callbacks\x18\x06 \x03(\v2(.sessionpb.Session.PendingCallbacksEntryR\x10pendingCallbacks\x12L\n" +
 	"\x0epending_inputs\x18\a \x03(\v2%.sessionpb.Session.PendingInputsEntryR\rpendingInputs\x12%\n" +
-	"\x0estage_messages\x18\b \x03(\x03R\rstageMessages\x1a_\n" +
+	"\x0estage_messages\x18\b \x03(\x03R\rstageMessages\x12#\n" +
+	"\rstage_history\x18\t \x03(\tR\fstageHistory\x123\n" +
+	"\n" +
+	"call_stack\x18\n" +
+	" \x03(\v2\x14.sessionpb.FlowFrameR\tcallStack\x12%\n" +
+	"\x0einput_failures\x18\v \x01(\x03R\rinputFailures\x12!\n" +
+	"\fcommand_menu\x18\f \x01(\tR\vcommandMenu\x1a_\n" +
 	"\x15PendingCallbacksEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x120\n" +
 	"\x05value\x18\x02 \x01(\v2\x1a.sessionpb.PendingCallbackR\x05value:\x028\x01\x1a@\n" +
@@ -363,30 +880,44 @@ func file_session_proto_rawDescGZIP() []byte {
 }
 
 var file_session_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_session_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_session_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_session_proto_goTypes = []any{
-	(CallbackBehaviour)(0),        // 0: sessionpb.CallbackBehaviour
-	(*PendingCallback)(nil),       // 1: sessionpb.PendingCallback
-	(*UserContext)(nil),           // 2: sessionpb.UserContext
-	(*Session)(nil),               // 3: sessionpb.Session
-	nil,                           // 4: sessionpb.Session.PendingCallbacksEntry
-	nil,                           // 5: sessionpb.Session.PendingInputsEntry
-	(*structpb.Struct)(nil),       // 6: google.protobuf.Struct
-	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+	(CallbackBehaviour)(0),              // 0: sessionpb.CallbackBehaviour
+	(*SwitchInlineQueryChosenChat)(nil), // 1: sessionpb.SwitchInlineQueryChosenChat
+	(*LoginUrl)(nil),                    // 2: sessionpb.LoginUrl
+	(*InlineButton)(nil),                // 3: sessionpb.InlineButton
+	(*MultiPageKeyboard)(nil),           // 4: sessionpb.MultiPageKeyboard
+	(*PendingCallback)(nil),             // 5: sessionpb.PendingCallback
+	(*UserContext)(nil),                 // 6: sessionpb.UserContext
+	(*FlowFrame)(nil),                   // 7: sessionpb.FlowFrame
+	(*Session)(nil),                     // 8: sessionpb.Session
+	nil,                                 // 9: sessionpb.FlowFrame.PendingInputsEntry
+	nil,                                 // 10: sessionpb.Session.PendingCallbacksEntry
+	nil,                                 // 11: sessionpb.Session.PendingInputsEntry
+	(*structpb.Struct)(nil),             // 12: google.protobuf.Struct
+	(*timestamppb.Timestamp)(nil),       // 13: google.protobuf.Timestamp
 }
 var file_session_proto_depIdxs = []int32{
-	0, // 0: sessionpb.PendingCallback.behaviour:type_name -> sessionpb.CallbackBehaviour
-	6, // 1: sessionpb.UserContext.misc:type_name -> google.protobuf.Struct
-	7, // 2: sessionpb.Session.expire_time:type_name -> google.protobuf.Timestamp
-	2, // 3: sessionpb.Session.context:type_name -> sessionpb.UserContext
-	4, // 4: sessionpb.Session.pending_callbacks:type_name -> sessionpb.Session.PendingCallbacksEntry
-	5, // 5: sessionpb.Session.pending_inputs:type_name -> sessionpb.Session.PendingInputsEntry
-	1, // 6: sessionpb.Session.PendingCallbacksEntry.value:type_name -> sessionpb.PendingCallback
-	7, // [7:7] is the sub-list for method output_type
-	7, // [7:7] is the sub-list for method input_type
-	7, // [7:7] is the sub-list for extension type_name
-	7, // [7:7] is the sub-list for extension extendee
-	0, // [0:7] is the sub-list for field type_name
+	0,  // 0: sessionpb.InlineButton.behaviour:type_name -> sessionpb.CallbackBehaviour
+	1,  // 1: sessionpb.InlineButton.chosen_chat:type_name -> sessionpb.SwitchInlineQueryChosenChat
+	2,  // 2: sessionpb.InlineButton.login_url:type_name -> sessionpb.LoginUrl
+	3,  // 3: sessionpb.MultiPageKeyboard.buttons:type_name -> sessionpb.InlineButton
+	0,  // 4: sessionpb.PendingCallback.behaviour:type_name -> sessionpb.CallbackBehaviour
+	4,  // 5: sessionpb.PendingCallback.page:type_name -> sessionpb.MultiPageKeyboard
+	12, // 6: sessionpb.UserContext.misc:type_name -> google.protobuf.Struct
+	12, // 7: sessionpb.FlowFrame.params:type_name -> google.protobuf.Struct
+	9,  // 8: sessionpb.FlowFrame.pending_inputs:type_name -> sessionpb.FlowFrame.PendingInputsEntry
+	13, // 9: sessionpb.Session.expire_time:type_name -> google.protobuf.Timestamp
+	6,  // 10: sessionpb.Session.context:type_name -> sessionpb.UserContext
+	10, // 11: sessionpb.Session.pending_callbacks:type_name -> sessionpb.Session.PendingCallbacksEntry
+	11, // 12: sessionpb.Session.pending_inputs:type_name -> sessionpb.Session.PendingInputsEntry
+	7,  // 13: sessionpb.Session.call_stack:type_name -> sessionpb.FlowFrame
+	5,  // 14: sessionpb.Session.PendingCallbacksEntry.value:type_name -> sessionpb.PendingCallback
+	15, // [15:15] is the sub-list for method output_type
+	15, // [15:15] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
 }
 
 func init() { file_session_proto_init() }
@@ -400,7 +931,7 @@ func file_session_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_session_proto_rawDesc), len(file_session_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   5,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
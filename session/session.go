@@ -3,6 +3,7 @@ package session
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/atsegelnyk/galaxia/model"
 	sessionpb "github.com/atsegelnyk/galaxia/pb"
 	"google.golang.org/protobuf/proto"
@@ -11,17 +12,36 @@ import (
 	"time"
 )
 
-const DefaultSessionTTL = 86400
+const (
+	DefaultSessionTTL = 86400
+	// MaxStageHistory bounds the stage history, the oldest stages are forgotten first.
+	MaxStageHistory = 20
+	// MaxCallDepth bounds the number of nested sub-flows.
+	MaxCallDepth = 8
+)
+
+var (
+	CallDepthExceededError = errors.New("sub-flow call depth exceeded")
+	NoFlowError            = errors.New("not in a sub-flow")
+	InvalidFlowParamsError = errors.New("sub-flow params are not JSON compatible")
+)
 
 type Session struct {
 	ExpireTime       time.Time
 	TTL              int64                             `json:"ttl"`
 	UserID           int64                             `json:"user_id"`
 	CurrentStage     model.ResourceRef                 `json:"current_stage"`
+	StageHistory     []model.ResourceRef               `json:"stage_history,omitempty"`
+	CallStack        []*model.FlowFrame                `json:"call_stack,omitempty"`
+	InputFailures    int                               `json:"input_failures,omitempty"`
 	UserContext      *model.UserContext                `json:"context"`
 	PendingCallbacks map[string]*model.PendingCallback `json:"pending_callbacks"`
 	PendingInputs    map[string]model.ResourceRef      `json:"pending_inputs"`
 	StageMessages    []int                             `json:"pending_messages"`
+
+	// CommandMenu is the digest of the command menu published for the chat, empty
+	// while the chat shows the default menu.
+	CommandMenu string `json:"command_menu,omitempty"`
 }
 
 func NewSession(userID int64, opts ...Option) *Session {
@@ -80,6 +100,89 @@ func (s *Session) GetCurrentStage() model.ResourceRef {
 
 func (s *Session) SetNextStage(nextStageRef model.ResourceRef) {
 	s.CurrentStage = nextStageRef
+	s.InputFailures = 0
+}
+
+// PushStage makes nextStageRef current and records the previous current stage in the history.
+func (s *Session) PushStage(nextStageRef model.ResourceRef) {
+	if !s.CurrentStage.Empty() {
+		s.StageHistory = append(s.StageHistory, s.CurrentStage)
+		if len(s.StageHistory) > MaxStageHistory {
+			s.StageHistory = s.StageHistory[len(s.StageHistory)-MaxStageHistory:]
+		}
+	}
+	s.SetNextStage(nextStageRef)
+}
+
+// PreviousStage returns the last stage of the history without removing it.
+func (s *Session) PreviousStage() (model.ResourceRef, bool) {
+	if len(s.StageHistory) == 0 {
+		return "", false
+	}
+	return s.StageHistory[len(s.StageHistory)-1], true
+}
+
+// PopStage makes the last stage of the history current again.
+func (s *Session) PopStage() (model.ResourceRef, bool) {
+	prev, ok := s.PreviousStage()
+	if !ok {
+		return "", false
+	}
+	s.StageHistory = s.StageHistory[:len(s.StageHistory)-1]
+	s.SetNextStage(prev)
+	return prev, true
+}
+
+// PushFlow records the return address of a sub-flow call. The stage history and
+// pending inputs of the caller are stashed in the frame, the sub-flow starts clean.
+// Params are checked up front since the frame could not be saved otherwise.
+func (s *Session) PushFlow(continuationRef model.ResourceRef, params map[string]interface{}) error {
+	if len(s.CallStack) >= MaxCallDepth {
+		return CallDepthExceededError
+	}
+	if params != nil {
+		if _, err := structpb.NewStruct(params); err != nil {
+			return fmt.Errorf("%w: %s", InvalidFlowParamsError, err)
+		}
+	}
+	s.CallStack = append(s.CallStack, &model.FlowFrame{
+		ReturnStage:     s.CurrentStage,
+		ContinuationRef: continuationRef,
+		Params:          params,
+		StageHistory:    s.StageHistory,
+		PendingInputs:   s.PendingInputs,
+	})
+	s.StageHistory = nil
+	s.PendingInputs = make(map[string]model.ResourceRef)
+	s.syncFlowParams()
+	return nil
+}
+
+// PopFlow leaves the current sub-flow and restores the caller stage state.
+func (s *Session) PopFlow() (*model.FlowFrame, error) {
+	if len(s.CallStack) == 0 {
+		return nil, NoFlowError
+	}
+	frame := s.CallStack[len(s.CallStack)-1]
+	s.CallStack = s.CallStack[:len(s.CallStack)-1]
+	s.SetNextStage(frame.ReturnStage)
+	s.StageHistory = frame.StageHistory
+	s.PendingInputs = frame.PendingInputs
+	if s.PendingInputs == nil {
+		s.PendingInputs = make(map[string]model.ResourceRef)
+	}
+	s.syncFlowParams()
+	return frame, nil
+}
+
+func (s *Session) syncFlowParams() {
+	if s.UserContext == nil {
+		return
+	}
+	s.UserContext.FlowParams = nil
+	if len(s.CallStack) > 0 {
+		s.UserContext.FlowParams = s.CallStack[len(s.CallStack)-1].Params
+	}
 }
 
 func (s *Session) RegisterCallback(id string, behaviour model.CallbackBehaviour, userData string, handlerRef model.ResourceRef) {
@@ -90,6 +193,14 @@ func (s *Session) RegisterCallback(id string, behaviour model.CallbackBehaviour,
 	}
 }
 
+// RegisterPageCallback registers a multi page keyboard navigation button leading to page.
+func (s *Session) RegisterPageCallback(id string, page *model.MultiPageInlineKeyboard) {
+	s.PendingCallbacks[id] = &model.PendingCallback{
+		Behaviour: model.Retain,
+		Page:      page,
+	}
+}
+
 func (s *Session) GetPendingCallback(callbackID string) (*model.PendingCallback, error) {
 	if cb, ok := s.PendingCallbacks[callbackID]; ok {
 		if cb.Behaviour == model.DeleteCallbackBehaviour {
@@ -100,6 +211,24 @@ func (s *Session) GetPendingCallback(callbackID string) (*model.PendingCallback,
 	return nil, errors.New("callback not found")
 }
 
+// BindCallbacks records the message the callback buttons were sent with.
+func (s *Session) BindCallbacks(messageID int, callbackIDs ...string) {
+	for _, id := range callbackIDs {
+		if cb, ok := s.PendingCallbacks[id]; ok {
+			cb.MessageID = messageID
+		}
+	}
+}
+
+// DropMessageCallbacks forgets the callbacks of the buttons attached to a message.
+func (s *Session) DropMessageCallbacks(messageID int) {
+	for id, cb := range s.PendingCallbacks {
+		if cb.MessageID == messageID {
+			delete(s.PendingCallbacks, id)
+		}
+	}
+}
+
 func (s *Session) AppendStageMessages(msgIDs ...int) {
 	s.StageMessages = append(s.StageMessages, msgIDs...)
 }
@@ -147,6 +276,8 @@ func (s *Session) MarshalProto() ([]byte, error) {
 			UserData:   v.UserData,
 			HandlerRef: string(v.HandlerRef),
 			Behaviour:  sessionpb.CallbackBehaviour(v.Behaviour),
+			MessageId:  int64(v.MessageID),
+			Page:       multiPageKeyboardToProto(v.Page),
 		}
 	}
 
@@ -163,6 +294,15 @@ func (s *Session) MarshalProto() ([]byte, error) {
 		pStageMessages = append(pStageMessages, int64(msg))
 	}
 
+	var pCallStack []*sessionpb.FlowFrame
+	for _, frame := range s.CallStack {
+		pFrame, err := flowFrameToProto(frame)
+		if err != nil {
+			return nil, err
+		}
+		pCallStack = append(pCallStack, pFrame)
+	}
+
 	return proto.Marshal(&sessionpb.Session{
 		ExpireTime:       timestamppb.New(s.ExpireTime),
 		Ttl:              s.TTL,
@@ -172,6 +312,10 @@ func (s *Session) MarshalProto() ([]byte, error) {
 		PendingCallbacks: pbCbs,
 		PendingInputs:    pInputs,
 		StageMessages:    pStageMessages,
+		StageHistory:     refsToProto(s.StageHistory),
+		CallStack:        pCallStack,
+		InputFailures:    int64(s.InputFailures),
+		CommandMenu:      s.CommandMenu,
 	})
 }
 
@@ -215,6 +359,8 @@ func (s *Session) UnmarshalProto(data []byte) error {
 				UserData:   v.UserData,
 				HandlerRef: model.ResourceRef(v.HandlerRef),
 				Behaviour:  model.CallbackBehaviour(v.Behaviour),
+				MessageID:  int(v.MessageId),
+				Page:       multiPageKeyboardFromProto(v.Page),
 			}
 		}
 	} else {
@@ -233,5 +379,164 @@ func (s *Session) UnmarshalProto(data []byte) error {
 	for _, msg := range ps.StageMessages {
 		s.StageMessages = append(s.StageMessages, int(msg))
 	}
+	s.StageHistory = refsFromProto(ps.StageHistory)
+	s.InputFailures = int(ps.InputFailures)
+	s.CommandMenu = ps.CommandMenu
+	for _, pFrame := range ps.CallStack {
+		s.CallStack = append(s.CallStack, flowFrameFromProto(pFrame))
+	}
+	s.syncFlowParams()
 	return nil
 }
+
+func refsToProto(refs []model.ResourceRef) []string {
+	var pRefs []string
+	for _, ref := range refs {
+		pRefs = append(pRefs, string(ref))
+	}
+	return pRefs
+}
+
+func refsFromProto(pRefs []string) []model.ResourceRef {
+	var refs []model.ResourceRef
+	for _, ref := range pRefs {
+		refs = append(refs, model.ResourceRef(ref))
+	}
+	return refs
+}
+
+func flowFrameToProto(frame *model.FlowFrame) (*sessionpb.FlowFrame, error) {
+	pFrame := &sessionpb.FlowFrame{
+		ReturnStage:     string(frame.ReturnStage),
+		ContinuationRef: string(frame.ContinuationRef),
+		StageHistory:    refsToProto(frame.StageHistory),
+		PendingInputs:   make(map[string]string, len(frame.PendingInputs)),
+	}
+	if frame.Params != nil {
+		params, err := structpb.NewStruct(frame.Params)
+		if err != nil {
+			return nil, err
+		}
+		pFrame.Params = params
+	}
+	for k, v := range frame.PendingInputs {
+		pFrame.PendingInputs[k] = string(v)
+	}
+	return pFrame, nil
+}
+
+func flowFrameFromProto(pFrame *sessionpb.FlowFrame) *model.FlowFrame {
+	frame := &model.FlowFrame{
+		ReturnStage:     model.ResourceRef(pFrame.ReturnStage),
+		ContinuationRef: model.ResourceRef(pFrame.ContinuationRef),
+		StageHistory:    refsFromProto(pFrame.StageHistory),
+		PendingInputs:   make(map[string]model.ResourceRef, len(pFrame.PendingInputs)),
+	}
+	if pFrame.Params != nil {
+		frame.Params = pFrame.Params.AsMap()
+	}
+	for k, v := range pFrame.PendingInputs {
+		frame.PendingInputs[k] = model.ResourceRef(v)
+	}
+	return frame
+}
+
+func multiPageKeyboardToProto(k *model.MultiPageInlineKeyboard) *sessionpb.MultiPageKeyboard {
+	if k == nil {
+		return nil
+	}
+	pk := &sessionpb.MultiPageKeyboard{
+		Offset:             int64(k.Offset),
+		PageSize:           int64(k.PageSize),
+		Layout:             int64(k.PageLayout),
+		NextButtonText:     k.NextButtonText,
+		PreviousButtonText: k.PreviousButtonText,
+	}
+	for _, r := range k.PageRows {
+		pk.PageRows = append(pk.PageRows, int64(r))
+	}
+	for _, b := range k.AllButtons {
+		pk.Buttons = append(pk.Buttons, inlineButtonToProto(b))
+	}
+	return pk
+}
+
+func inlineButtonToProto(b *model.InlineButton) *sessionpb.InlineButton {
+	pb := &sessionpb.InlineButton{
+		Text:       b.Text,
+		UserData:   b.UserData,
+		HandlerRef: string(b.CallbackHandlerRef),
+		Behaviour:  sessionpb.CallbackBehaviour(b.CallbackBehaviour),
+		Kind:       int64(b.Kind),
+		Url:        b.URL,
+		Query:      b.Query,
+	}
+	if b.ChosenChat != nil {
+		pb.ChosenChat = &sessionpb.SwitchInlineQueryChosenChat{
+			Query:             b.ChosenChat.Query,
+			AllowUserChats:    b.ChosenChat.AllowUserChats,
+			AllowBotChats:     b.ChosenChat.AllowBotChats,
+			AllowGroupChats:   b.ChosenChat.AllowGroupChats,
+			AllowChannelChats: b.ChosenChat.AllowChannelChats,
+		}
+	}
+	if b.LoginURL != nil {
+		pb.LoginUrl = &sessionpb.LoginUrl{
+			Url:                b.LoginURL.URL,
+			ForwardText:        b.LoginURL.ForwardText,
+			BotUsername:        b.LoginURL.BotUsername,
+			RequestWriteAccess: b.LoginURL.RequestWriteAccess,
+		}
+	}
+	return pb
+}
+
+func multiPageKeyboardFromProto(pk *sessionpb.MultiPageKeyboard) *model.MultiPageInlineKeyboard {
+	if pk == nil {
+		return nil
+	}
+	k := &model.MultiPageInlineKeyboard{
+		Offset:             int(pk.Offset),
+		PageSize:           int(pk.PageSize),
+		PageLayout:         model.KeyboardLayout(pk.Layout),
+		NextButtonText:     pk.NextButtonText,
+		PreviousButtonText: pk.PreviousButtonText,
+	}
+	for _, r := range pk.PageRows {
+		k.PageRows = append(k.PageRows, int(r))
+	}
+	for _, b := range pk.Buttons {
+		k.AllButtons = append(k.AllButtons, inlineButtonFromProto(b))
+	}
+	return k
+}
+
+func inlineButtonFromProto(pb *sessionpb.InlineButton) *model.InlineButton {
+	b := &model.InlineButton{
+		Kind:               model.InlineButtonKind(pb.Kind),
+		Text:               pb.Text,
+		UserData:           pb.UserData,
+		CallbackHandlerRef: model.ResourceRef(pb.HandlerRef),
+		CallbackBehaviour:  model.CallbackBehaviour(pb.Behaviour),
+		URL:                pb.Url,
+		Query:              pb.Query,
+	}
+	if pb.ChosenChat != nil {
+		b.ChosenChat = &model.SwitchInlineQueryChosenChat{
+			Query:             pb.ChosenChat.Query,
+			AllowUserChats:    pb.ChosenChat.AllowUserChats,
+			AllowBotChats:     pb.ChosenChat.AllowBotChats,
+			AllowGroupChats:   pb.ChosenChat.AllowGroupChats,
+			AllowChannelChats: pb.ChosenChat.AllowChannelChats,
+		}
+	}
+	if pb.LoginUrl != nil {
+		b.LoginURL = &model.LoginURL{
+			URL:                pb.LoginUrl.Url,
+			ForwardText:        pb.LoginUrl.ForwardText,
+			BotUsername:        pb.LoginUrl.BotUsername,
+			RequestWriteAccess: pb.LoginUrl.RequestWriteAccess,
+		}
+	}
+	return b
+}
@@ -0,0 +1,106 @@
+package galaxia
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/atsegelnyk/galaxia/model"
+	"github.com/atsegelnyk/galaxia/session"
+	"github.com/atsegelnyk/galaxia/timers"
+)
+
+const timerPollInterval = time.Second
+
+// armTimers replaces the armed timers of the user with the timers of the current stage,
+// counted from now. It runs after every user update, which cancels pending reminders.
+func (p *Processor) armTimers(ses *session.Session) {
+	if p.timerStore == nil {
+		return
+	}
+	var armed []*timers.Timer
+	if stageRef := ses.GetCurrentStage(); !stageRef.Empty() {
+		stg, err := p.entityRegistry.GetStage(ses.UserID, stageRef)
+		if err == nil {
+			now := time.Now()
+			for i, timer := range stg.Timers() {
+				armed = append(armed, &timers.Timer{
+					UserID:   ses.UserID,
+					StageRef: stageRef,
+					Index:    i,
+					FireAt:   now.Add(timer.After),
+				})
+			}
+		}
+	}
+	err := p.timerStore.Schedule(ses.UserID, armed...)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// processDetachedUpdate processes an update not caused by the user. Timers are only
+// re-armed when the update moved the user to another stage.
+func (p *Processor) processDetachedUpdate(ctx context.Context, ses *session.Session, update *model.UserUpdate) error {
+	stageRef := ses.GetCurrentStage()
+	err := p.processUserUpdate(ctx, ses, update)
+	if ses.GetCurrentStage() != stageRef {
+		p.armTimers(ses)
+	}
+	return err
+}
+
+func (p *Processor) runTimers(ctx context.Context) {
+	ticker := time.NewTicker(timerPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			due, err := p.timerStore.PopDue(now)
+			if err != nil {
+				log.Println(err)
+			}
+			for _, timer := range due {
+				err = p.fireTimer(ctx, timer)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+// fireTimer goes through the async update path like any update not caused by the user.
+// Timers of a stage the user has already left are skipped, a panic fails the timer
+// alone rather than the timer loop.
+func (p *Processor) fireTimer(ctx context.Context, timer *timers.Timer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("timer %d of stage %s for user %d panicked: %v", timer.Index, timer.StageRef, timer.UserID, r)
+		}
+	}()
+	return p.asyncUpdate(ctx, timer.UserID, func(ses *session.Session) (*model.UserUpdate, error) {
+		if ses.GetCurrentStage() != timer.StageRef {
+			return nil, nil
+		}
+		stg, err := p.entityRegistry.GetStage(timer.UserID, timer.StageRef)
+		if err != nil {
+			return nil, err
+		}
+		if timer.Index >= len(stg.Timers()) {
+			return nil, nil
+		}
+		stageTimer := stg.Timers()[timer.Index]
+		if stageTimer.ActionRef.Empty() {
+			return stageTimer.Update(timer.UserID), nil
+		}
+		action, err := p.entityRegistry.GetAction(timer.UserID, stageTimer.ActionRef)
+		if err != nil {
+			return nil, err
+		}
+		return p.runDetachedAction(ses, action)
+	})
+}
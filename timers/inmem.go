@@ -0,0 +1,58 @@
+package timers
+
+import (
+	"sync"
+	"time"
+)
+
+type InMemoryStore struct {
+	mu     sync.Mutex
+	timers map[int64][]*Timer
+}
+
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{
+		mu:     sync.Mutex{},
+		timers: make(map[int64][]*Timer),
+	}
+}
+
+func (s *InMemoryStore) Schedule(userID int64, timers ...*Timer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(timers) == 0 {
+		delete(s.timers, userID)
+		return nil
+	}
+	s.timers[userID] = timers
+	return nil
+}
+
+func (s *InMemoryStore) Cancel(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.timers, userID)
+	return nil
+}
+
+func (s *InMemoryStore) PopDue(now time.Time) ([]*Timer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*Timer
+	for userID, timers := range s.timers {
+		var pending []*Timer
+		for _, timer := range timers {
+			if timer.FireAt.After(now) {
+				pending = append(pending, timer)
+			} else {
+				due = append(due, timer)
+			}
+		}
+		if len(pending) == 0 {
+			delete(s.timers, userID)
+		} else {
+			s.timers[userID] = pending
+		}
+	}
+	return due, nil
+}
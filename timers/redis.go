@@ -0,0 +1,169 @@
+package timers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// both keys share the {timers} hash tag, cluster transactions need a single slot
+	dueKey        = "{timers}"
+	userKey       = "{timers}:%d"
+	popBatch      = 100
+	maxTxAttempts = 10
+)
+
+// RedisStore keeps timers in a sorted set scored by fire time, next to the sessions
+// of RedisSessionRepository. A per user set tracks the members to cancel.
+type RedisStore struct {
+	ctx           context.Context
+	keyPrefix     string
+	client        *redis.Client
+	clusterClient *redis.ClusterClient
+}
+
+type RedisStoreOption func(*RedisStore)
+
+func NewRedisStore(ctx context.Context, opts ...RedisStoreOption) *RedisStore {
+	s := &RedisStore{
+		ctx: ctx,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func WithClient(client *redis.Client) RedisStoreOption {
+	return func(s *RedisStore) {
+		s.client = client
+	}
+}
+
+func WithClusterClient(client *redis.ClusterClient) RedisStoreOption {
+	return func(s *RedisStore) {
+		s.clusterClient = client
+	}
+}
+
+func WithKeyPrefix(prefix string) RedisStoreOption {
+	return func(s *RedisStore) {
+		s.keyPrefix = prefix
+	}
+}
+
+// Schedule replaces the timers of the user in a transaction watching the user set,
+// so concurrent schedules and pops never leave a half-written schedule behind.
+func (s *RedisStore) Schedule(userID int64, timers ...*Timer) error {
+	members := make([]redis.Z, 0, len(timers))
+	names := make([]interface{}, 0, len(timers))
+	for _, timer := range timers {
+		member, err := json.Marshal(timer)
+		if err != nil {
+			return err
+		}
+		members = append(members, redis.Z{
+			Score:  float64(timer.FireAt.UnixMilli()),
+			Member: string(member),
+		})
+		names = append(names, string(member))
+	}
+
+	userKey := s.buildUserKey(userID)
+	replace := func(tx *redis.Tx) error {
+		armed, err := tx.SMembers(s.ctx, userKey).Result()
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			if len(armed) > 0 {
+				stale := make([]interface{}, 0, len(armed))
+				for _, member := range armed {
+					stale = append(stale, member)
+				}
+				pipe.ZRem(s.ctx, s.buildKey(dueKey), stale...)
+			}
+			pipe.Del(s.ctx, userKey)
+			if len(members) > 0 {
+				pipe.SAdd(s.ctx, userKey, names...)
+				pipe.ZAdd(s.ctx, s.buildKey(dueKey), members...)
+			}
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < maxTxAttempts; i++ {
+		err := s.watch(replace, userKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("scheduling timers of user %d: %w", userID, redis.TxFailedErr)
+}
+
+func (s *RedisStore) Cancel(userID int64) error {
+	return s.Schedule(userID)
+}
+
+// PopDue claims due timers with ZREM, so a timer removed by another processor in
+// the meantime is skipped.
+func (s *RedisStore) PopDue(now time.Time) ([]*Timer, error) {
+	members, err := s.cmd().ZRangeByScore(s.ctx, s.buildKey(dueKey), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: popBatch,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []*Timer
+	for _, member := range members {
+		removed, err := s.cmd().ZRem(s.ctx, s.buildKey(dueKey), member).Result()
+		if err != nil {
+			return due, err
+		}
+		if removed == 0 {
+			continue
+		}
+		var timer Timer
+		err = json.Unmarshal([]byte(member), &timer)
+		if err != nil {
+			return due, err
+		}
+		_ = s.cmd().SRem(s.ctx, s.buildUserKey(timer.UserID), member)
+		due = append(due, &timer)
+	}
+	return due, nil
+}
+
+func (s *RedisStore) watch(fn func(*redis.Tx) error, keys ...string) error {
+	if s.client != nil {
+		return s.client.Watch(s.ctx, fn, keys...)
+	}
+	return s.clusterClient.Watch(s.ctx, fn, keys...)
+}
+
+func (s *RedisStore) cmd() redis.Cmdable {
+	if s.client != nil {
+		return s.client
+	}
+	return s.clusterClient
+}
+
+func (s *RedisStore) buildUserKey(userID int64) string {
+	return s.buildKey(fmt.Sprintf(userKey, userID))
+}
+
+func (s *RedisStore) buildKey(key string) string {
+	if s.keyPrefix != "" {
+		return s.keyPrefix + key
+	}
+	return key
+}
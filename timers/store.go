@@ -0,0 +1,24 @@
+package timers
+
+import (
+	"time"
+
+	"github.com/atsegelnyk/galaxia/model"
+)
+
+// Timer is an armed stage timer of a user, Index points into the stage timers.
+type Timer struct {
+	UserID   int64             `json:"user_id"`
+	StageRef model.ResourceRef `json:"stage_ref"`
+	Index    int               `json:"index"`
+	FireAt   time.Time         `json:"fire_at"`
+}
+
+// Store keeps armed timers. PopDue must hand every timer out only once, even when
+// several processors share the store.
+type Store interface {
+	// Schedule replaces all armed timers of the user.
+	Schedule(userID int64, timers ...*Timer) error
+	Cancel(userID int64) error
+	PopDue(now time.Time) ([]*Timer, error)
+}
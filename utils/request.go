@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/atsegelnyk/galaxia/model"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Request is a raw Bot API method call. tgbotapi configs cover only a subset of
+// the API parameters, so outgoing messages are built and sent as plain requests.
+type Request struct {
+	Method string
+	Params map[string]string
+	Files  map[string]*model.InputFile
+}
+
+func NewRequest(method string) *Request {
+	return &Request{
+		Method: method,
+		Params: make(map[string]string),
+		Files:  make(map[string]*model.InputFile),
+	}
+}
+
+// SetFile puts file into the request under field, either by reference or as an upload.
+func (r *Request) SetFile(field string, file *model.InputFile) {
+	switch {
+	case file.FileID != "":
+		r.Params[field] = file.FileID
+	case file.URL != "":
+		r.Params[field] = file.URL
+	default:
+		r.Files[field] = file
+	}
+}
+
+// AttachFile registers file as a multipart part named name and returns the value to
+// reference it from JSON parameters such as media group items.
+func (r *Request) AttachFile(name string, file *model.InputFile) string {
+	switch {
+	case file.FileID != "":
+		return file.FileID
+	case file.URL != "":
+		return file.URL
+	default:
+		r.Files[name] = file
+		return "attach://" + name
+	}
+}
+
+func (r *Request) SetJSON(field string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.Params[field] = string(data)
+	return nil
+}
+
+// Do executes the request and returns the raw result field of the API response.
+func Do(api *tgbotapi.BotAPI, req *Request) (json.RawMessage, error) {
+	if len(req.Files) == 0 {
+		values := url.Values{}
+		for k, v := range req.Params {
+			values.Set(k, v)
+		}
+		resp, err := api.MakeRequest(req.Method, values)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Result, nil
+	}
+	return upload(api, req)
+}
+
+func DoMessage(api *tgbotapi.BotAPI, req *Request) (tgbotapi.Message, error) {
+	var msg tgbotapi.Message
+	result, err := Do(api, req)
+	if err != nil {
+		return msg, err
+	}
+	err = json.Unmarshal(result, &msg)
+	return msg, err
+}
+
+// DoMessages executes a request that returns either a single message or an array of
+// messages, as sendMediaGroup does.
+func DoMessages(api *tgbotapi.BotAPI, req *Request) ([]tgbotapi.Message, error) {
+	result, err := Do(api, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > 0 && result[0] == '[' {
+		var msgs []tgbotapi.Message
+		err = json.Unmarshal(result, &msgs)
+		return msgs, err
+	}
+	var msg tgbotapi.Message
+	err = json.Unmarshal(result, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return []tgbotapi.Message{msg}, nil
+}
+
+// IsNotModifiedError reports whether an edit failed only because the new content
+// equals the current one, which Telegram treats as an error.
+func IsNotModifiedError(err error) bool {
+	var apiErr tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		return strings.Contains(apiErr.Message, "message is not modified")
+	}
+	return false
+}
+
+func upload(api *tgbotapi.BotAPI, req *Request) (json.RawMessage, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeMultipart(writer, req))
+	}()
+
+	resp, err := api.Client.Post(fmt.Sprintf(tgbotapi.APIEndpoint, api.Token, req.Method), writer.FormDataContentType(), pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp tgbotapi.APIResponse
+	err = json.NewDecoder(resp.Body).Decode(&apiResp)
+	if err != nil {
+		return nil, err
+	}
+	if !apiResp.Ok {
+		parameters := tgbotapi.ResponseParameters{}
+		if apiResp.Parameters != nil {
+			parameters = *apiResp.Parameters
+		}
+		return nil, tgbotapi.Error{Message: apiResp.Description, ResponseParameters: parameters}
+	}
+	return apiResp.Result, nil
+}
+
+func writeMultipart(writer *multipart.Writer, req *Request) error {
+	for k, v := range req.Params {
+		err := writer.WriteField(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	for field, file := range req.Files {
+		err := writeFilePart(writer, field, file)
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeFilePart(writer *multipart.Writer, field string, file *model.InputFile) error {
+	var reader io.Reader
+	name := file.Name
+	switch {
+	case file.Bytes != nil:
+		reader = bytes.NewReader(file.Bytes)
+	case file.Reader != nil:
+		reader = file.Reader
+	case file.Path != "":
+		f, err := os.Open(file.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+		if name == "" {
+			name = filepath.Base(file.Path)
+		}
+	default:
+		return fmt.Errorf("file %s has no source", field)
+	}
+	if name == "" {
+		name = field
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, field, escapeQuotes(name)))
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, reader)
+	return err
+}
+
+func escapeQuotes(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
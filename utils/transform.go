@@ -1,10 +1,468 @@
 package utils
 
 import (
+	"strconv"
+	"unicode/utf8"
+
 	"github.com/atsegelnyk/galaxia/model"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
+const CaptionMaxLength = 1024
+
+var mediaMethods = map[model.MediaType]string{
+	model.PhotoMedia:     "sendPhoto",
+	model.VideoMedia:     "sendVideo",
+	model.DocumentMedia:  "sendDocument",
+	model.AudioMedia:     "sendAudio",
+	model.VoiceMedia:     "sendVoice",
+	model.AnimationMedia: "sendAnimation",
+	model.StickerMedia:   "sendSticker",
+	model.VideoNoteMedia: "sendVideoNote",
+}
+
+func TransformMessagesRequests(userID int64, models []*model.Message) []*Request {
+	var reqs []*Request
+	for _, m := range models {
+		reqs = append(reqs, TransformMessageRequests(userID, m)...)
+	}
+	return reqs
+}
+
+// TransformMessageRequests builds the requests needed to deliver a single message.
+// Text is sent as the media caption when the media type allows it and it fits,
+// otherwise it follows the media as a separate text message.
+func TransformMessageRequests(userID int64, model *model.Message) []*Request {
+	reqs := transformMessage(userID, model)
+	for i, req := range reqs {
+		setSendOptions(req, model, i == 0)
+	}
+	return reqs
+}
+
+func transformMessage(userID int64, model *model.Message) []*Request {
+	if model.MediaGroup != nil {
+		return transformMediaGroupMessage(userID, model)
+	}
+	if model.Media == nil {
+		return []*Request{TransformText(userID, model)}
+	}
+
+	media := TransformMedia(userID, model.Media)
+	if model.Text == "" {
+		setReplyMarkup(media, model)
+		return []*Request{media}
+	}
+	if model.Media.Type.SupportsCaption() && utf8.RuneCountInString(model.Text) <= CaptionMaxLength {
+		media.Params["caption"] = model.Text
+		setParseMode(media, model.ParseMode)
+		setReplyMarkup(media, model)
+		return []*Request{media}
+	}
+	return []*Request{media, TransformText(userID, model)}
+}
+
+func TransformText(userID int64, model *model.Message) *Request {
+	req := NewRequest("sendMessage")
+	req.Params["chat_id"] = strconv.FormatInt(userID, 10)
+	req.Params["text"] = model.Text
+	setParseMode(req, model.ParseMode)
+	setReplyMarkup(req, model)
+	return req
+}
+
+func TransformMedia(userID int64, media *model.Media) *Request {
+	req := NewRequest(mediaMethods[media.Type])
+	req.Params["chat_id"] = strconv.FormatInt(userID, 10)
+	req.SetFile(string(media.Type), media.File)
+	return req
+}
+
+// transformMediaGroupMessage sends the album and puts the text into the caption of the
+// first item when possible. Albums can't carry keyboards, so a message with a keyboard
+// gets its text sent separately right after the album.
+func transformMediaGroupMessage(userID int64, msg *model.Message) []*Request {
+	var caption string
+	if !msg.HasReplyMarkup() && msg.MediaGroup.Media[0].Caption == "" && utf8.RuneCountInString(msg.Text) <= CaptionMaxLength {
+		caption = msg.Text
+	}
+
+	reqs := []*Request{TransformMediaGroup(userID, msg.MediaGroup, caption, msg.ParseMode)}
+	if msg.Text != "" && caption == "" {
+		reqs = append(reqs, TransformText(userID, msg))
+	}
+	return reqs
+}
+
+type inputMedia struct {
+	Type      model.MediaType `json:"type"`
+	Media     string          `json:"media"`
+	Caption   string          `json:"caption,omitempty"`
+	ParseMode model.ParseMode `json:"parse_mode,omitempty"`
+}
+
+// TransformMediaGroup builds a sendMediaGroup request, caption overrides the
+// caption of the first item when set. parseMode applies to all item captions.
+func TransformMediaGroup(userID int64, group *model.MediaGroup, caption string, parseMode model.ParseMode) *Request {
+	req := NewRequest("sendMediaGroup")
+	req.Params["chat_id"] = strconv.FormatInt(userID, 10)
+
+	items := make([]inputMedia, 0, len(group.Media))
+	for i, media := range group.Media {
+		item := inputMedia{
+			Type:    media.Type,
+			Media:   req.AttachFile("file"+strconv.Itoa(i), media.File),
+			Caption: media.Caption,
+		}
+		if i == 0 && caption != "" {
+			item.Caption = caption
+		}
+		if item.Caption != "" {
+			item.ParseMode = parseMode
+		}
+		items = append(items, item)
+	}
+	_ = req.SetJSON("media", items)
+	return req
+}
+
+func TransformReplyMarkup(model *model.Message) interface{} {
+	switch {
+	case model.ReplyKeyboard != nil:
+		return transformReplyKeyboard(model.ReplyKeyboard, model.ReplyKeyboardOptions)
+	case model.InlineKeyboard != nil:
+		return transformInlineKeyboard(model.InlineKeyboard)
+	case model.RemoveKeyboard != nil:
+		return &replyKeyboardRemove{
+			RemoveKeyboard: true,
+			Selective:      model.RemoveKeyboard.Selective,
+		}
+	case model.ForceReply != nil:
+		return &forceReply{
+			ForceReply:            true,
+			InputFieldPlaceholder: model.ForceReply.Placeholder,
+			Selective:             model.ForceReply.Selective,
+		}
+	}
+	return nil
+}
+
+type replyKeyboardMarkup struct {
+	Keyboard              [][]keyboardButton `json:"keyboard"`
+	IsPersistent          bool               `json:"is_persistent,omitempty"`
+	ResizeKeyboard        bool               `json:"resize_keyboard,omitempty"`
+	OneTimeKeyboard       bool               `json:"one_time_keyboard,omitempty"`
+	InputFieldPlaceholder string             `json:"input_field_placeholder,omitempty"`
+	Selective             bool               `json:"selective,omitempty"`
+}
+
+type replyKeyboardRemove struct {
+	RemoveKeyboard bool `json:"remove_keyboard"`
+	Selective      bool `json:"selective,omitempty"`
+}
+
+type forceReply struct {
+	ForceReply            bool   `json:"force_reply"`
+	InputFieldPlaceholder string `json:"input_field_placeholder,omitempty"`
+	Selective             bool   `json:"selective,omitempty"`
+}
+
+type keyboardButtonPollType struct {
+	Type model.PollType `json:"type,omitempty"`
+}
+
+// keyboardButton mirrors the Bot API type, tgbotapi lacks the poll, users and chat requests.
+type keyboardButton struct {
+	Text            string                  `json:"text"`
+	RequestContact  bool                    `json:"request_contact,omitempty"`
+	RequestLocation bool                    `json:"request_location,omitempty"`
+	RequestPoll     *keyboardButtonPollType `json:"request_poll,omitempty"`
+	RequestUsers    *model.UsersRequest     `json:"request_users,omitempty"`
+	RequestChat     *model.ChatRequest      `json:"request_chat,omitempty"`
+}
+
+func transformReplyKeyboard(replyKeyboard [][]*model.ReplyButton, options *model.ReplyKeyboardOptions) *replyKeyboardMarkup {
+	if options == nil {
+		options = &model.ReplyKeyboardOptions{Resize: true}
+	}
+	keyboard := &replyKeyboardMarkup{
+		Keyboard:              make([][]keyboardButton, 0, len(replyKeyboard)),
+		IsPersistent:          options.Persistent,
+		ResizeKeyboard:        options.Resize,
+		OneTimeKeyboard:       options.OneTime,
+		InputFieldPlaceholder: options.Placeholder,
+		Selective:             options.Selective,
+	}
+	for _, r := range replyKeyboard {
+		row := make([]keyboardButton, 0, len(r))
+		for _, b := range r {
+			row = append(row, transformReplyButton(b))
+		}
+		keyboard.Keyboard = append(keyboard.Keyboard, row)
+	}
+	return keyboard
+}
+
+func transformReplyButton(b *model.ReplyButton) keyboardButton {
+	button := keyboardButton{
+		Text: b.Text,
+	}
+	switch b.Kind {
+	case model.ContactReplyButton:
+		button.RequestContact = true
+	case model.LocationReplyButton:
+		button.RequestLocation = true
+	case model.PollReplyButton:
+		button.RequestPoll = &keyboardButtonPollType{Type: b.PollType}
+	case model.UsersReplyButton:
+		button.RequestUsers = b.UsersRequest
+	case model.ChatReplyButton:
+		button.RequestChat = b.ChatRequest
+	}
+	return button
+}
+
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type webAppInfo struct {
+	URL string `json:"url"`
+}
+
+type copyTextButton struct {
+	Text string `json:"text"`
+}
+
+// inlineKeyboardButton mirrors the Bot API type, tgbotapi lacks the newer button kinds.
+type inlineKeyboardButton struct {
+	Text                         string                             `json:"text"`
+	URL                          string                             `json:"url,omitempty"`
+	CallbackData                 string                             `json:"callback_data,omitempty"`
+	WebApp                       *webAppInfo                        `json:"web_app,omitempty"`
+	LoginURL                     *model.LoginURL                    `json:"login_url,omitempty"`
+	SwitchInlineQuery            *string                            `json:"switch_inline_query,omitempty"`
+	SwitchInlineQueryCurrentChat *string                            `json:"switch_inline_query_current_chat,omitempty"`
+	SwitchInlineQueryChosenChat  *model.SwitchInlineQueryChosenChat `json:"switch_inline_query_chosen_chat,omitempty"`
+	CopyText                     *copyTextButton                    `json:"copy_text,omitempty"`
+	Pay                          bool                               `json:"pay,omitempty"`
+}
+
+func transformInlineKeyboard(inlineKeyboard [][]*model.InlineButton) *inlineKeyboardMarkup {
+	keyboard := &inlineKeyboardMarkup{
+		InlineKeyboard: make([][]inlineKeyboardButton, 0, len(inlineKeyboard)),
+	}
+	for _, r := range inlineKeyboard {
+		row := make([]inlineKeyboardButton, 0, len(r))
+		for _, b := range r {
+			row = append(row, transformInlineButton(b))
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+	}
+	return keyboard
+}
+
+func transformInlineButton(b *model.InlineButton) inlineKeyboardButton {
+	button := inlineKeyboardButton{
+		Text: b.Text,
+	}
+	switch b.Kind {
+	case model.URLButton:
+		button.URL = b.URL
+	case model.SwitchInlineQueryButton:
+		query := b.Query
+		button.SwitchInlineQuery = &query
+	case model.SwitchInlineQueryCurrentChatButton:
+		query := b.Query
+		button.SwitchInlineQueryCurrentChat = &query
+	case model.SwitchInlineQueryChosenChatButton:
+		button.SwitchInlineQueryChosenChat = b.ChosenChat
+		if button.SwitchInlineQueryChosenChat == nil {
+			button.SwitchInlineQueryChosenChat = &model.SwitchInlineQueryChosenChat{}
+		}
+	case model.LoginURLButton:
+		button.LoginURL = b.LoginURL
+	case model.WebAppButton:
+		button.WebApp = &webAppInfo{URL: b.URL}
+	case model.CopyTextButton:
+		button.CopyText = &copyTextButton{Text: b.Query}
+	case model.PayButton:
+		button.Pay = true
+	default:
+		button.CallbackData = b.Data
+	}
+	return button
+}
+
+var editMethods = map[model.EditKind]string{
+	model.EditText:        "editMessageText",
+	model.EditCaption:     "editMessageCaption",
+	model.EditMedia:       "editMessageMedia",
+	model.EditReplyMarkup: "editMessageReplyMarkup",
+}
+
+// TransformEdit builds the edit request for an already sent message, the message
+// id of the edit has to be resolved beforehand. Telegram drops the inline keyboard
+// of an edited message unless the edit carries one.
+func TransformEdit(userID int64, edit *model.Edit) *Request {
+	req := NewRequest(editMethods[edit.Kind])
+	req.Params["chat_id"] = strconv.FormatInt(userID, 10)
+	req.Params["message_id"] = strconv.Itoa(edit.MessageID)
+
+	msg := edit.Message
+	if msg == nil {
+		msg = model.NewMessage()
+	}
+	switch edit.Kind {
+	case model.EditText:
+		req.Params["text"] = msg.Text
+		setParseMode(req, msg.ParseMode)
+		if msg.LinkPreview != nil {
+			_ = req.SetJSON("link_preview_options", msg.LinkPreview)
+		}
+	case model.EditCaption:
+		req.Params["caption"] = msg.Text
+		setParseMode(req, msg.ParseMode)
+	case model.EditMedia:
+		if msg.Media != nil {
+			item := inputMedia{
+				Type:    msg.Media.Type,
+				Media:   req.AttachFile("file0", msg.Media.File),
+				Caption: msg.Text,
+			}
+			if item.Caption != "" {
+				item.ParseMode = msg.ParseMode
+			}
+			_ = req.SetJSON("media", item)
+		}
+	}
+	if msg.InlineKeyboard != nil {
+		_ = req.SetJSON("reply_markup", transformInlineKeyboard(msg.InlineKeyboard))
+	}
+	return req
+}
+
+type replyParameters struct {
+	MessageID                int  `json:"message_id"`
+	AllowSendingWithoutReply bool `json:"allow_sending_without_reply"`
+}
+
+// setSendOptions applies message send options to req. A message may be split into
+// several requests, only the first one quotes the reply target and plays the effect.
+func setSendOptions(req *Request, model *model.Message, first bool) {
+	if model.DisableNotification {
+		req.Params["disable_notification"] = "true"
+	}
+	if model.ProtectContent {
+		req.Params["protect_content"] = "true"
+	}
+	if model.LinkPreview != nil && req.Method == "sendMessage" {
+		_ = req.SetJSON("link_preview_options", model.LinkPreview)
+	}
+	if !first {
+		return
+	}
+	if model.ReplyToMessageID != 0 {
+		_ = req.SetJSON("reply_parameters", replyParameters{
+			MessageID:                model.ReplyToMessageID,
+			AllowSendingWithoutReply: true,
+		})
+	}
+	if model.EffectID != "" {
+		req.Params["message_effect_id"] = model.EffectID
+	}
+}
+
+func setParseMode(req *Request, mode model.ParseMode) {
+	if mode != model.PlainText {
+		req.Params["parse_mode"] = string(mode)
+	}
+}
+
+func setReplyMarkup(req *Request, model *model.Message) {
+	markup := TransformReplyMarkup(model)
+	if markup == nil {
+		return
+	}
+	_ = req.SetJSON("reply_markup", markup)
+}
+
+func TransformCallbackQueryResponse(model *model.CallbackQueryResponse) tgbotapi.CallbackConfig {
+	return tgbotapi.CallbackConfig{
+		Text:            model.Text,
+		CallbackQueryID: model.CallbackQueryID,
+	}
+}
+
+// SentFileID extracts the file_id Telegram assigned to the media of a sent message.
+func SentFileID(msg tgbotapi.Message, mediaType model.MediaType) string {
+	switch mediaType {
+	case model.PhotoMedia:
+		if msg.Photo != nil && len(*msg.Photo) > 0 {
+			photos := *msg.Photo
+			return photos[len(photos)-1].FileID
+		}
+	case model.VideoMedia:
+		if msg.Video != nil {
+			return msg.Video.FileID
+		}
+	case model.DocumentMedia:
+		if msg.Document != nil {
+			return msg.Document.FileID
+		}
+	case model.AudioMedia:
+		if msg.Audio != nil {
+			return msg.Audio.FileID
+		}
+	case model.VoiceMedia:
+		if msg.Voice != nil {
+			return msg.Voice.FileID
+		}
+	case model.AnimationMedia:
+		if msg.Animation != nil {
+			return msg.Animation.FileID
+		}
+	case model.StickerMedia:
+		if msg.Sticker != nil {
+			return msg.Sticker.FileID
+		}
+	case model.VideoNoteMedia:
+		if msg.VideoNote != nil {
+			return msg.VideoNote.FileID
+		}
+	}
+	return ""
+}
+
+// TransformSetMyCommands publishes the command menu for scope, an empty languageCode
+// applies to users without a dedicated menu for their language.
+func TransformSetMyCommands(commands []model.BotCommand, scope *model.CommandScope, languageCode string) *Request {
+	req := NewRequest("setMyCommands")
+	if commands == nil {
+		commands = []model.BotCommand{}
+	}
+	_ = req.SetJSON("commands", commands)
+	setCommandScope(req, scope, languageCode)
+	return req
+}
+
+// TransformDeleteMyCommands drops the menu of scope, users fall back to a broader scope.
+func TransformDeleteMyCommands(scope *model.CommandScope, languageCode string) *Request {
+	req := NewRequest("deleteMyCommands")
+	setCommandScope(req, scope, languageCode)
+	return req
+}
+
+func setCommandScope(req *Request, scope *model.CommandScope, languageCode string) {
+	if scope != nil {
+		_ = req.SetJSON("scope", scope)
+	}
+	if languageCode != "" {
+		req.Params["language_code"] = languageCode
+	}
+}
+
+// Deprecated: TransformPhoto only covers photo uploads, use TransformMessageRequests.
 func TransformPhoto(userID int64, photo []byte) tgbotapi.PhotoConfig {
 	photoFileBytes := tgbotapi.FileBytes{
 		Name:  "pic",
@@ -13,57 +471,30 @@ func TransformPhoto(userID int64, photo []byte) tgbotapi.PhotoConfig {
 	return tgbotapi.NewPhotoUpload(userID, photoFileBytes)
 }
 
+// Deprecated: TransformVideo only covers video uploads, use TransformMessageRequests.
 func TransformVideo(userID int64, video []byte) tgbotapi.VideoConfig {
-	photoFileBytes := tgbotapi.FileBytes{
+	videoFileBytes := tgbotapi.FileBytes{
 		Name:  "myvid",
 		Bytes: video,
 	}
-	return tgbotapi.NewVideoUpload(userID, photoFileBytes)
+	return tgbotapi.NewVideoUpload(userID, videoFileBytes)
 }
 
+// Deprecated: TransformMessages drops media and send options, use TransformMessagesRequests.
 func TransformMessages(userID int64, models []*model.Message) []tgbotapi.Chattable {
 	var cbs []tgbotapi.Chattable
 	for _, m := range models {
-		cfg := TransformMessage(userID, m)
-		cbs = append(cbs, cfg)
+		cbs = append(cbs, TransformMessage(userID, m))
 	}
 	return cbs
 }
 
+// Deprecated: TransformMessage drops media and send options, use TransformMessageRequests.
 func TransformMessage(userID int64, model *model.Message) *tgbotapi.MessageConfig {
 	messageConfig := tgbotapi.NewMessage(userID, model.Text)
-	if model.InlineKeyboard != nil {
-		keyboard := tgbotapi.NewInlineKeyboardMarkup()
-		for _, r := range model.InlineKeyboard {
-			row := tgbotapi.NewInlineKeyboardRow()
-			for _, b := range r {
-				button := tgbotapi.NewInlineKeyboardButtonData(b.Text, b.Data)
-				row = append(row, button)
-			}
-			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
-		}
-		messageConfig.ReplyMarkup = &keyboard
-	}
-	if model.ReplyKeyboard != nil {
-		keyboard := tgbotapi.NewReplyKeyboard()
-		for _, r := range model.ReplyKeyboard {
-			row := tgbotapi.NewKeyboardButtonRow()
-			for _, b := range r {
-				button := tgbotapi.KeyboardButton{
-					Text: b.Text,
-				}
-				row = append(row, button)
-			}
-			keyboard.Keyboard = append(keyboard.Keyboard, row)
-		}
-		messageConfig.ReplyMarkup = &keyboard
+	messageConfig.ParseMode = string(model.ParseMode)
+	if markup := TransformReplyMarkup(model); markup != nil {
+		messageConfig.ReplyMarkup = markup
 	}
 	return &messageConfig
 }
-
-func TransformCallbackQueryResponse(model *model.CallbackQueryResponse) tgbotapi.CallbackConfig {
-	return tgbotapi.CallbackConfig{
-		Text:            model.Text,
-		CallbackQueryID: model.CallbackQueryID,
-	}
-}